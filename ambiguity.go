@@ -0,0 +1,108 @@
+package grammar
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// An AmbiguityAudit records the places where more than one alternative of a
+// OneOf (or Longest) rule matches.  Pass it to Parse with WithAmbiguityAudit.
+// The zero value is ready to use.  Positions are token positions, so it only
+// makes sense to use the same audit for several parses of the same input, but
+// it is safe to use it concurrently.
+type AmbiguityAudit struct {
+	mu          sync.Mutex
+	ambiguities []Ambiguity
+	seen        map[string]struct{}
+}
+
+// An Ambiguity is a place where several alternatives of a rule match.
+type Ambiguity struct {
+	Rule         string
+	Alternatives []AmbiguousMatch // In the order of the fields of the rule
+	Chosen       int              // Position in Alternatives of the one kept by the parser
+}
+
+// An AmbiguousMatch is an alternative which matched, with the span of tokens it
+// matched.
+type AmbiguousMatch struct {
+	Field string
+	Span  Span
+}
+
+func (a Ambiguity) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "@%d %s:", a.Alternatives[0].Span.Start, a.Rule)
+	for i, m := range a.Alternatives {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, " %s %s", m.Field, m.Span)
+		if i == a.Chosen {
+			b.WriteString(" (chosen)")
+		}
+	}
+	return b.String()
+}
+
+// WithAmbiguityAudit makes OneOf rules try all their fields, recording into a
+// the places where more than one matches.  The fields after the one which
+// matched first are only tried to find ambiguities, so the result of parsing
+// is unchanged, but parsing is slower.
+func WithAmbiguityAudit(a *AmbiguityAudit) ParseOption {
+	return func(s *ParserState) {
+		s.audit = a
+	}
+}
+
+// Ambiguities returns the ambiguities found so far, ordered by position then
+// rule.  When backtracking makes the parser try a rule several times at the
+// same position, the ambiguity is only reported once.
+func (a *AmbiguityAudit) Ambiguities() []Ambiguity {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ambiguities := append([]Ambiguity(nil), a.ambiguities...)
+	sort.SliceStable(ambiguities, func(i, j int) bool {
+		si, sj := ambiguities[i].Alternatives[0].Span, ambiguities[j].Alternatives[0].Span
+		if si.Start != sj.Start {
+			return si.Start < sj.Start
+		}
+		return ambiguities[i].Rule < ambiguities[j].Rule
+	})
+	return ambiguities
+}
+
+// WriteReport writes the ambiguities found so far, one per line.
+func (a *AmbiguityAudit) WriteReport(out io.Writer) error {
+	for _, amb := range a.Ambiguities() {
+		if _, err := fmt.Fprintln(out, amb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record records that several alternatives of a rule matched, the one kept
+// being the chosen field.
+func (a *AmbiguityAudit) record(rule string, chosen string, matches []AmbiguousMatch) {
+	amb := Ambiguity{Rule: rule, Alternatives: matches}
+	for i, m := range matches {
+		if m.Field == chosen {
+			amb.Chosen = i
+		}
+	}
+	key := amb.String()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.seen[key]; ok {
+		return
+	}
+	if a.seen == nil {
+		a.seen = map[string]struct{}{}
+	}
+	a.seen[key] = struct{}{}
+	a.ambiguities = append(a.ambiguities, amb)
+}
@@ -0,0 +1,58 @@
+package grammar
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAmbiguityAudit(t *testing.T) {
+	const src = "a is type; a * b; c * d;"
+	want := `@0 usStmt: Typedef @0-4 (chosen), Expr @0-4
+@4 usStmt: Decl @4-8 (chosen), Expr @4-8
+`
+	var audit AmbiguityAudit
+	plain := parseBothEngines(t, &usProgram{}, src)
+	audited := parseBothEngines(t, &usProgram{}, src, WithAmbiguityAudit(&audit))
+	if plain.Err != nil {
+		t.Fatal(plain.Err)
+	}
+	if !reflect.DeepEqual(plain, audited) {
+		t.Errorf("The audit changed the result")
+	}
+	var b strings.Builder
+	if err := audit.WriteReport(&b); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != want {
+		t.Errorf("Got:\n%s\nWant:\n%s", got, want)
+	}
+}
+
+func TestAmbiguityAuditLongest(t *testing.T) {
+	var audit AmbiguityAudit
+	if err := parseBothEngines(t, &lgProgram{}, "a b;", WithAmbiguityAudit(&audit)).Err; err != nil {
+		t.Fatal(err)
+	}
+	ambiguities := audit.Ambiguities()
+	if len(ambiguities) != 1 {
+		t.Fatalf("Got %v", ambiguities)
+	}
+	want := "@0 lgStmt: A @0-1, AB @0-2 (chosen), Ident @0-1, Words @0-2"
+	if got := ambiguities[0].String(); got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+}
+
+// The error for an input which does not parse is the same with the audit.
+func TestAmbiguityAuditError(t *testing.T) {
+	const src = "a is type; a * ;"
+	plain := parseBothEngines(t, &usProgram{}, src)
+	audited := parseBothEngines(t, &usProgram{}, src, WithAmbiguityAudit(&AmbiguityAudit{}))
+	if plain.Err == nil {
+		t.Fatal("Expected an error")
+	}
+	if plain.String() != audited.String() {
+		t.Errorf("Got %q with the audit, want %q", audited, plain)
+	}
+}
@@ -22,10 +22,6 @@ func main() {
 	// Setup
 	log.SetFlags(0)
 	flag.Parse()
-	parseFuncTemplate, err := template.New("parse").Parse(parseFunc)
-	if err != nil {
-		log.Fatalf("Invalid template: %s", err)
-	}
 
 	// Extract the source file
 	var srcFile string
@@ -53,9 +49,29 @@ func main() {
 	if err != nil {
 		log.Fatalf("Cannot parse file: %s", srcFile)
 	}
+	compiled, err := generate(srcFile, astFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Output the code
+	outFile := getOutputPath(srcFile)
+	log.Printf("Writing generated code to %s", outFile)
+	if err := os.WriteFile(outFile, compiled, 0644); err != nil {
+		log.Fatalf("Error writing compiled file to %s: %s", outFile, err)
+	}
+}
+
+// generate returns the formatted code of the Parse methods for the rules
+// defined in astFile.
+func generate(srcFile string, astFile *ast.File) ([]byte, error) {
+	parseFuncTemplate, err := template.New("parse").Parse(parseFunc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %s", err)
+	}
 	grammarPackageName := getGrammarPackageName(astFile)
 	if grammarPackageName == "" {
-		log.Fatalf("Package github.com/arnodel/grammar not imported")
+		return nil, fmt.Errorf("package github.com/arnodel/grammar not imported")
 	}
 	ruleTypes := getRuleTypes(astFile.Scope.Objects, grammarPackageName)
 
@@ -76,22 +92,18 @@ func main() {
 			return ruleTypes[name] != nil
 		})
 		log.Printf("...generating (*%s).Parse", name)
-		parseFuncTemplate.Execute(&compiledBuf, rule)
+		if err := parseFuncTemplate.Execute(&compiledBuf, rule); err != nil {
+			return nil, err
+		}
 	}
 
 	// Format the generated code
 	log.Print("Formatting generated code")
 	compiled, err := format.Source(compiledBuf.Bytes())
 	if err != nil {
-		log.Fatalf("Error formatting code: %s", err)
-	}
-
-	// Output the code
-	outFile := getOutputPath(srcFile)
-	log.Printf("Writing generated code to %s", outFile)
-	if err := os.WriteFile(outFile, compiled, 0644); err != nil {
-		log.Fatalf("Error writing compiled file to %s: %s", outFile, err)
+		return nil, fmt.Errorf("error formatting code: %s", err)
 	}
+	return compiled, nil
 }
 
 func getGrammarPackageName(f *ast.File) string {
@@ -134,6 +146,12 @@ func getRuleTypes(objects map[string]*ast.Object, grammarPackageName string) map
 		if firstFieldTypeName != grammarPackageName+".Seq" && firstFieldTypeName != grammarPackageName+".OneOf" {
 			continue
 		}
+		if typeSpec.TypeParams != nil {
+			// The generated code cannot depend on the type arguments, so
+			// generic rules are parsed using reflection.
+			log.Printf("...skipping generic rule %s", name)
+			continue
+		}
 		ruleTypes[name] = structType
 	}
 	return ruleTypes
@@ -156,7 +174,9 @@ func getRule(typeName string, structType *ast.StructType, grammarPackageName str
 		if !fieldType.IsValid() {
 			panic(fmt.Sprintf("Invalid field %s in type %s", fieldName, typeName))
 		}
-		fieldType.IsRule = isRuleType(fieldType.Name)
+		// Instances of generic rules (e.g. grammar.Optional[Value]) have no
+		// generated Parse method.
+		fieldType.IsRule = !fieldType.Generic && isRuleType(fieldType.Name)
 		var options ParseOptions
 		if field.Tag != nil {
 			tag, _ := strconv.Unquote(field.Tag.Value)
@@ -302,6 +322,7 @@ type FieldType struct {
 	Pointer bool
 	Array   bool
 	IsRule  bool
+	Generic bool // An instance of a generic type, e.g. grammar.Optional[Value]
 }
 
 func (f FieldType) IsValid() bool {
@@ -325,16 +346,27 @@ func getFieldType(e ast.Expr) FieldType {
 	switch ee := e.(type) {
 	case *ast.ArrayType:
 		return FieldType{
-			Name:  getName(ee.Elt),
-			Array: true,
+			Name:    getName(ee.Elt),
+			Array:   true,
+			Generic: isGeneric(ee.Elt),
 		}
 	case *ast.StarExpr:
 		return FieldType{
 			Name:    getName(ee.X),
 			Pointer: true,
+			Generic: isGeneric(ee.X),
 		}
 	default:
-		return FieldType{Name: getName(e)}
+		return FieldType{Name: getName(e), Generic: isGeneric(e)}
+	}
+}
+
+func isGeneric(e ast.Expr) bool {
+	switch e.(type) {
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		return true
+	default:
+		return false
 	}
 }
 
@@ -356,11 +388,31 @@ func getName(e ast.Expr) string {
 			return ""
 		}
 		return fmt.Sprintf("%s.%s", x, sel)
+	case *ast.IndexExpr:
+		// Instance of a generic type, e.g. grammar.Optional[Value]
+		return getGenericName(ee.X, ee.Index)
+	case *ast.IndexListExpr:
+		return getGenericName(ee.X, ee.Indices...)
 	default:
 		return ""
 	}
 }
 
+func getGenericName(x ast.Expr, args ...ast.Expr) string {
+	name := getName(x)
+	if name == "" {
+		return ""
+	}
+	argNames := make([]string, len(args))
+	for i, arg := range args {
+		argNames[i] = getName(arg)
+		if argNames[i] == "" {
+			return ""
+		}
+	}
+	return fmt.Sprintf("%s[%s]", name, strings.Join(argNames, ", "))
+}
+
 func getUnqualifiedName(e ast.Expr) string {
 	switch ee := e.(type) {
 	case *ast.StarExpr:
@@ -369,6 +421,10 @@ func getUnqualifiedName(e ast.Expr) string {
 		return ee.Name
 	case *ast.SelectorExpr:
 		return getUnqualifiedName(ee.Sel)
+	case *ast.IndexExpr:
+		return getUnqualifiedName(ee.X)
+	case *ast.IndexListExpr:
+		return getUnqualifiedName(ee.X)
 	default:
 		return ""
 	}
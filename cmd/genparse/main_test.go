@@ -0,0 +1,82 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+const genericGrammar = `package lang
+
+import "github.com/arnodel/grammar"
+
+type Token = grammar.SimpleToken
+
+type Call struct {
+	grammar.Seq
+	Name Token ` + "`tok:\"ident\"`" + `
+	Args grammar.Delimited[grammar.SepBy[Value, Comma], Open, Close]
+	Kw   *grammar.Optional[Value]
+	Rest []Pair[Value]
+}
+
+type Value struct {
+	grammar.OneOf
+	Call   *Call
+	Number *Token ` + "`tok:\"number\"`" + `
+}
+
+type Pair[T any] struct {
+	grammar.Seq
+	Left  T
+	Right T
+}
+
+type Comma struct {
+	grammar.Seq
+	Comma grammar.Match ` + "`tok:\"op,,\"`" + `
+}
+
+type Open struct {
+	grammar.Seq
+	Open grammar.Match ` + "`tok:\"op,(\"`" + `
+}
+
+type Close struct {
+	grammar.Seq
+	Close grammar.Match ` + "`tok:\"op,)\"`" + `
+}
+`
+
+func TestGenerateGenericRules(t *testing.T) {
+	astFile, err := parser.ParseFile(token.NewFileSet(), "lang.go", genericGrammar, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled, err := generate("lang.go", astFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := string(compiled)
+	for _, want := range []string{
+		"func (r *Call) Parse(",
+		"func (r *Value) Parse(",
+		"var dest grammar.Delimited[grammar.SepBy[Value, Comma], Open, Close]",
+		"var dest grammar.Optional[Value]",
+		"var dest Pair[Value]",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("Generated code does not contain %q:\n%s", want, code)
+		}
+	}
+	// Instances of generic rules are parsed using reflection, so only the
+	// Call field of Value uses a generated Parse method.  Generic rules have
+	// no generated Parse method.
+	if got := strings.Count(code, "dest.Parse("); got != 1 {
+		t.Errorf("Got %d fields parsed with a generated method, want 1:\n%s", got, code)
+	}
+	if strings.Contains(code, "func (r *Pair") {
+		t.Errorf("Generated code contains a Parse method for Pair:\n%s", code)
+	}
+}
@@ -0,0 +1,153 @@
+package grammar
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A program cannot start with a let expression.  The lookahead checks that
+// committed errors do not escape lookahead fields.
+type cmProgram struct {
+	Seq
+	NotLet cmLet `lookahead:"not"`
+	Exprs  []cmExpr
+}
+
+type cmExpr struct {
+	OneOf
+	Let    *cmLet
+	Call   *cmCall
+	Atom   *SimpleToken `tok:"ident"`
+	Number *SimpleToken `tok:"number"`
+}
+
+// Once "(let" has been seen, this cannot be a call.
+type cmLet struct {
+	Seq
+	Open  Match `tok:"op,("`
+	Let   Match `tok:"ident,let"`
+	Cut   Commit
+	Name  SimpleToken `tok:"ident"`
+	Value cmExpr
+	Close Match `tok:"op,)"`
+}
+
+type cmCall struct {
+	Seq
+	Open  Match `tok:"op,("`
+	Items []cmExpr
+	Close Match `tok:"op,)"`
+}
+
+func TestCommit(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{
+			src:  "(f (let x 1))",
+			want: "cmProgram {Exprs: [cmExpr {Call: cmCall {Open: {}, Items: [cmExpr {Atom: {ident f}}, cmExpr {Let: cmLet {Open: {}, Let: {}, Name: {ident x}, Value: cmExpr {Number: {number 1}}, Close: {}}}], Close: {}}}]} @8",
+		},
+		{
+			// Without the Commit field, this would be parsed as a call.
+			src:  "(f (let 1 x))",
+			want: `error: token #4 number with value "1": expected token with type ident`,
+		},
+		{
+			// Without the Commit field, the error would be about the first
+			// token of the program.
+			src:  "(let 1 x)",
+			want: `error: token #2 number with value "1": expected token with type ident`,
+		},
+		{
+			src:  "(let x 1)",
+			want: `error: token #0 op with value "(": unexpected cmLet`,
+		},
+		{
+			src:  "(f (let x 1)",
+			want: `error: token #7 EOF with value "EOF": expected token with type ident or number, or value "(" or ")"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.src, func(t *testing.T) {
+			got := parseBothEngines(t, &cmProgram{}, test.src).String()
+			if got != test.want {
+				t.Errorf("Got:\n%s\nWant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+// The separators of Items are parsed with the items, before the Commit field,
+// so the rule is committed before Close.
+type cmNumberList struct {
+	Seq
+	Open  Match         `tok:"op,("`
+	Items []SimpleToken `tok:"ident" sep:"@cmNumber"`
+	Cut   Commit
+	Close Match `tok:"op,)"`
+}
+
+func init() {
+	MustRegisterRule(cmNumber{})
+}
+
+type cmNumber struct {
+	Seq
+	Number SimpleToken `tok:"number"`
+}
+
+type cmUnclosed struct {
+	Seq
+	Open  Match         `tok:"op,("`
+	Items []SimpleToken `tok:"ident|number"`
+}
+
+type cmList struct {
+	OneOf
+	Numbers  *cmNumberList
+	Unclosed *cmUnclosed
+}
+
+func TestCommitBeforeSeparators(t *testing.T) {
+	want := `error: token #4 EOF with value "EOF": expected token with value ")"`
+	if got := parseBothEngines(t, &cmList{}, "(a 1 b").String(); got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+}
+
+func TestCommitRuleDef(t *testing.T) {
+	ruleDef, err := calcRuleDef(reflect.TypeOf(cmLet{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ruleField := range ruleDef.Fields {
+		got = append(got, fmt.Sprintf("%s:%t", ruleField.Name, ruleField.Commit))
+	}
+	want := "Open:false Let:false Name:true Value:false Close:false"
+	if strings.Join(got, " ") != want {
+		t.Errorf("Got %s, want %s", got, want)
+	}
+
+	type optional struct {
+		Seq
+		Cut *Commit
+	}
+	type inOneOf struct {
+		OneOf
+		Cut []Commit
+	}
+	type last struct {
+		Seq
+		Open Match `tok:"op,("`
+		Cut  Commit
+	}
+	for _, r := range []interface{}{optional{}, inOneOf{}, last{}} {
+		if _, err := calcRuleDef(reflect.TypeOf(r)); err == nil {
+			t.Errorf("Expected error for %T", r)
+		}
+	}
+}
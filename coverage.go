@@ -0,0 +1,281 @@
+package grammar
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// A Coverage records, across any number of parses, which fields of which
+// rules were tried and whether they matched.  Pass it to Parse with
+// WithCoverage, then report the results with WriteTable or WriteHTML.  It is
+// safe to use the same Coverage in concurrent parses.
+type Coverage struct {
+	mu    sync.Mutex
+	rules []*RuleCoverage
+	index map[*RuleDef]*RuleCoverage
+}
+
+// RuleCoverage is the coverage of the fields of a rule.
+type RuleCoverage struct {
+	Name   string
+	OneOf  bool
+	Fields []FieldCoverage
+}
+
+// FieldCoverage is the coverage of a field of a rule.  For a one-of rule,
+// each field is an alternative.
+type FieldCoverage struct {
+	Name     string
+	Optional bool // True if the field is a pointer
+	Repeated bool // True if the field is a slice
+
+	Tried   int // Number of times the field was tried
+	Matched int // Number of times the field matched (at least one item for repeated fields)
+	Failed  int // Number of times the field did not match anything
+
+	// For repeated fields, the number of times each number of items was
+	// matched.
+	Repetitions map[int]int
+}
+
+// Covered returns true if the field matched at least once.
+func (f FieldCoverage) Covered() bool {
+	return f.Matched > 0
+}
+
+// NewCoverage returns a new Coverage which reports on the given rules and all
+// the rules they refer to, even if they are never tried.  Other rules are
+// reported as soon as they are tried.
+func NewCoverage(rules ...interface{}) (*Coverage, error) {
+	c := &Coverage{index: map[*RuleDef]*RuleCoverage{}}
+	for _, r := range rules {
+		tp := reflect.TypeOf(r)
+		if tp != nil && tp.Kind() == reflect.Ptr {
+			tp = tp.Elem()
+		}
+		ruleDefs, err := reachableRuleDefs(tp)
+		if err != nil {
+			return nil, err
+		}
+		for _, ruleDef := range ruleDefs {
+			c.ruleCoverage(ruleDef.RuleDef)
+		}
+	}
+	return c, nil
+}
+
+// WithCoverage makes the parser record the fields it tries into c.
+func WithCoverage(c *Coverage) ParseOption {
+	return func(s *ParserState) {
+		s.coverage = c
+	}
+}
+
+// ruleCoverage returns the coverage of the rule, adding it if it is not
+// already known.  The caller must hold the lock if c is in use.
+func (c *Coverage) ruleCoverage(ruleDef *RuleDef) *RuleCoverage {
+	if rc, ok := c.index[ruleDef]; ok {
+		return rc
+	}
+	rc := &RuleCoverage{
+		Name:   ruleDef.Name,
+		OneOf:  ruleDef.OneOf,
+		Fields: make([]FieldCoverage, len(ruleDef.Fields)),
+	}
+	for i, ruleField := range ruleDef.Fields {
+		rc.Fields[i] = FieldCoverage{
+			Name:     ruleField.Name,
+			Optional: ruleField.Pointer,
+			Repeated: ruleField.Array,
+		}
+	}
+	c.index[ruleDef] = rc
+	c.rules = append(c.rules, rc)
+	return rc
+}
+
+// record records an attempt at matching the field at position i in the rule.
+// The count is the number of items matched for repeated fields, or -1 if the
+// field is not repeated or the repetition failed.
+func (c *Coverage) record(ruleDef *RuleDef, i int, matched bool, count int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f := &c.ruleCoverage(ruleDef).Fields[i]
+	f.Tried++
+	if matched {
+		f.Matched++
+	} else {
+		f.Failed++
+	}
+	if count >= 0 {
+		if f.Repetitions == nil {
+			f.Repetitions = map[int]int{}
+		}
+		f.Repetitions[count]++
+	}
+}
+
+// Rules returns a copy of the coverage of all the rules, in the order they
+// were first encountered.
+func (c *Coverage) Rules() []RuleCoverage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rules := make([]RuleCoverage, len(c.rules))
+	for i, rc := range c.rules {
+		rules[i] = *rc
+		rules[i].Fields = make([]FieldCoverage, len(rc.Fields))
+		for j, f := range rc.Fields {
+			if f.Repetitions != nil {
+				reps := make(map[int]int, len(f.Repetitions))
+				for n, k := range f.Repetitions {
+					reps[n] = k
+				}
+				f.Repetitions = reps
+			}
+			rules[i].Fields[j] = f
+		}
+	}
+	return rules
+}
+
+// Percent returns the percentage of fields which were covered.
+func (c *Coverage) Percent() float64 {
+	covered, total := 0, 0
+	for _, rc := range c.Rules() {
+		for _, f := range rc.Fields {
+			total++
+			if f.Covered() {
+				covered++
+			}
+		}
+	}
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(covered) / float64(total)
+}
+
+// WriteTable outputs a table with a line for each field of each rule, e.g.
+//
+//	FIELD        TRIED  MATCHED  FAILED  NOTES
+//	Json.String  12     3        9
+//	Json.Number  9      0        9       NOT COVERED
+//	Array.Items  4      3        1       0:1 1:1 3:2
+//	...
+//	coverage: 85.0% of fields
+//
+// Notes give the number of times each number of items was matched for repeated
+// fields (e.g. 3:2 means 3 items were matched twice).  Fields which never
+// matched are marked NOT COVERED, or NOT TRIED if they were never tried.
+func (c *Coverage) WriteTable(out io.Writer) error {
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FIELD\tTRIED\tMATCHED\tFAILED\tNOTES")
+	for _, rc := range c.Rules() {
+		for _, f := range rc.Fields {
+			fmt.Fprintf(w, "%s.%s\t%d\t%d\t%d\t%s\n", rc.Name, f.Name, f.Tried, f.Matched, f.Failed, f.note())
+		}
+	}
+	w.Flush()
+	// The tabwriter pads the empty notes, so trim trailing spaces.
+	var table strings.Builder
+	for _, line := range strings.SplitAfter(b.String(), "\n") {
+		if line != "" {
+			table.WriteString(strings.TrimRight(line, " \n") + "\n")
+		}
+	}
+	fmt.Fprintf(&table, "coverage: %.1f%% of fields\n", c.Percent())
+	_, err := io.WriteString(out, table.String())
+	return err
+}
+
+// WriteHTML outputs a standalone HTML page showing the coverage of each rule,
+// with fields that were covered in green, fields that were tried but never
+// matched in orange and fields that were never tried in red.
+func (c *Coverage) WriteHTML(out io.Writer) error {
+	return coverageTemplate.Execute(out, struct {
+		Percent string
+		Rules   []RuleCoverage
+	}{
+		Percent: fmt.Sprintf("%.1f%%", c.Percent()),
+		Rules:   c.Rules(),
+	})
+}
+
+// note returns the repetition counts of the field, or a warning if it is not
+// covered.
+func (f FieldCoverage) note() string {
+	switch {
+	case f.Tried == 0:
+		return "NOT TRIED"
+	case !f.Covered():
+		return "NOT COVERED"
+	case f.Repeated:
+		return f.repetitionsLabel()
+	default:
+		return ""
+	}
+}
+
+// repetitionsLabel returns the histogram of repetition counts, e.g.
+// "0:1 1:1 3:2".
+func (f FieldCoverage) repetitionsLabel() string {
+	counts := make([]int, 0, len(f.Repetitions))
+	for n := range f.Repetitions {
+		counts = append(counts, n)
+	}
+	sort.Ints(counts)
+	parts := make([]string, len(counts))
+	for i, n := range counts {
+		parts[i] = strconv.Itoa(n) + ":" + strconv.Itoa(f.Repetitions[n])
+	}
+	return strings.Join(parts, " ")
+}
+
+func (f FieldCoverage) class() string {
+	switch {
+	case f.Tried == 0:
+		return "untried"
+	case !f.Covered():
+		return "uncovered"
+	default:
+		return "covered"
+	}
+}
+
+var coverageTemplate = template.Must(template.New("coverage").Funcs(template.FuncMap{
+	"class":       FieldCoverage.class,
+	"repetitions": FieldCoverage.repetitionsLabel,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Grammar coverage</title>
+<style>
+body { font-family: monospace; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { padding: 2px 8px; text-align: right; }
+th:first-child, td:first-child, td:last-child { text-align: left; }
+.covered { background: #c8f0c8; }
+.uncovered { background: #f8d8a0; }
+.untried { background: #f0b0b0; }
+</style>
+</head>
+<body>
+<h1>Grammar coverage: {{.Percent}} of fields</h1>
+{{range .Rules}}<h2>{{.Name}}{{if .OneOf}} (one of){{else}} (seq){{end}}</h2>
+<table>
+<tr><th>Field</th><th>Tried</th><th>Matched</th><th>Failed</th><th>Repetitions</th></tr>
+{{range .Fields}}<tr class="{{class .}}"><td>{{.Name}}{{if .Optional}}?{{end}}{{if .Repeated}}*{{end}}</td><td>{{.Tried}}</td><td>{{.Matched}}</td><td>{{.Failed}}</td><td>{{if .Repeated}}{{repetitions .}}{{end}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,128 @@
+package grammar
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func parseWithCoverage(t *testing.T, cov *Coverage, srcs ...string) {
+	t.Helper()
+	for _, src := range srcs {
+		stream, err := testTokenise(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expr testExpr
+		if err := Parse(&expr, stream, WithCoverage(cov)); err != nil {
+			t.Fatalf("Error parsing %q: %s", src, err)
+		}
+	}
+}
+
+func TestCoverageTable(t *testing.T) {
+	tests := []struct {
+		name string
+		srcs []string
+		want string
+	}{
+		{
+			name: "partial",
+			srcs: []string{"1", "2"},
+			want: `FIELD            TRIED  MATCHED  FAILED  NOTES
+testExpr.Number  2      2        0
+testExpr.Atom    0      0        0       NOT TRIED
+testExpr.List    0      0        0       NOT TRIED
+testList.Open    0      0        0       NOT TRIED
+testList.Items   0      0        0       NOT TRIED
+testList.Close   0      0        0       NOT TRIED
+coverage: 16.7% of fields
+`,
+		},
+		{
+			name: "full",
+			srcs: []string{"1", "(a (1))", "()"},
+			want: `FIELD            TRIED  MATCHED  FAILED  NOTES
+testExpr.Number  9      2        7
+testExpr.Atom    7      1        6
+testExpr.List    6      3        3
+testList.Open    6      3        3
+testList.Items   3      2        1       0:1 1:1 2:1
+testList.Close   3      3        0
+coverage: 100.0% of fields
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cov, err := NewCoverage(testExpr{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			parseWithCoverage(t, cov, test.srcs...)
+			var b strings.Builder
+			if err := cov.WriteTable(&b); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != test.want {
+				t.Errorf("Got:\n%s\nWant:\n%s", b.String(), test.want)
+			}
+		})
+	}
+}
+
+func TestCoverageHTML(t *testing.T) {
+	cov, err := NewCoverage(testExpr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parseWithCoverage(t, cov, "(a)")
+	var b strings.Builder
+	if err := cov.WriteHTML(&b); err != nil {
+		t.Fatal(err)
+	}
+	html := b.String()
+	for _, want := range []string{
+		"<h1>Grammar coverage: 83.3% of fields</h1>",
+		`<tr class="uncovered"><td>Number?</td><td>3</td><td>0</td><td>3</td><td></td></tr>`,
+		`<tr class="covered"><td>Items*</td><td>1</td><td>1</td><td>0</td><td>1:1</td></tr>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("Missing %q in:\n%s", want, html)
+		}
+	}
+}
+
+// Rule definitions are computed on first use, which must be safe in
+// concurrent parses (run with -race).
+func TestCoverageConcurrent(t *testing.T) {
+	type ccProgram struct {
+		Seq
+		Exprs []testExpr
+		End   Match `tok:"EOF"`
+	}
+	cov, err := NewCoverage(testExpr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stream, err := testTokenise("1 (a)")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			var p ccProgram
+			if err := Parse(&p, stream, WithCoverage(cov)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := cov.Rules()[0].Fields[0].Matched; got != 4 {
+		t.Errorf("Got %d matches of testExpr.Number, want 4", got)
+	}
+}
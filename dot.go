@@ -0,0 +1,210 @@
+package grammar
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// DotWriteTree outputs the parse tree r in the Graphviz DOT format, e.g. to be
+// rendered with
+//
+//	dot -Tsvg tree.dot > tree.svg
+//
+// Rules are drawn as boxes and tokens as ellipses.  Edges are labelled with the
+// name of the field holding the child (and its index for repeated fields).
+// Fields with no content (such as Match fields) are omitted.
+func DotWriteTree(out io.Writer, r interface{}) error {
+	v := reflect.ValueOf(r)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	node, err := marshalNode(v)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	fmt.Fprintln(w, "digraph tree {")
+	fmt.Fprintln(w, "  node [fontname=monospace];")
+	if node != nil {
+		var count int
+		dotWriteTreeNode(w, node, &count)
+	}
+	fmt.Fprintln(w, "}")
+	return w.Flush()
+}
+
+func dotWriteTreeNode(w io.Writer, node *treeNode, count *int) string {
+	id := fmt.Sprintf("n%d", *count)
+	*count++
+	if node.Token != nil {
+		fmt.Fprintf(w, "  %s [shape=ellipse, label=%s];\n", id, dotQuote(node.Token.Type, strconv.Quote(node.Token.Value)))
+		return id
+	}
+	fmt.Fprintf(w, "  %s [shape=box, label=%s];\n", id, dotQuote(node.Rule))
+	for _, field := range node.Fields {
+		if field.Node != nil {
+			childID := dotWriteTreeNode(w, field.Node, count)
+			fmt.Fprintf(w, "  %s -> %s [label=%s];\n", id, childID, dotQuote(field.Name))
+		}
+		for i, item := range field.Items {
+			childID := dotWriteTreeNode(w, item, count)
+			fmt.Fprintf(w, "  %s -> %s [label=%s];\n", id, childID, dotQuote(fmt.Sprintf("%s[%d]", field.Name, i)))
+		}
+	}
+	return id
+}
+
+// DotWriteRuleGraph outputs the graph of the rule r and all the rules it refers
+// to in the Graphviz DOT format.  Sequence rules are drawn as boxes and one-of
+// rules as diamonds.  There is an edge from each rule to the rule or token of
+// each of its fields, labelled with the field name (prefixed with its position
+// in sequence rules).  Edges for optional fields are dashed and edges for
+// repeated fields are bold, with the number of repetitions as a suffix.  Edges
+// for lookahead fields are dotted and prefixed with & (and) or ! (not).
+func DotWriteRuleGraph(out io.Writer, r interface{}) error {
+	tp := reflect.TypeOf(r)
+	if tp != nil && tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	ruleDefs, err := reachableRuleDefs(tp)
+	if err != nil {
+		return err
+	}
+	ids := map[reflect.Type]string{}
+	for i, ruleDef := range ruleDefs {
+		ids[ruleDef.Type] = fmt.Sprintf("r%d", i)
+	}
+	w := bufio.NewWriter(out)
+	fmt.Fprintln(w, "digraph rules {")
+	fmt.Fprintln(w, "  node [fontname=monospace];")
+	for _, ruleDef := range ruleDefs {
+		id := ids[ruleDef.Type]
+		shape, kind := "box", "seq"
+		switch {
+		case ruleDef.Longest:
+			shape, kind = "diamond", "longest"
+		case ruleDef.OneOf:
+			shape, kind = "diamond", "one of"
+		}
+		lines := []string{ruleDef.Name, "(" + kind + ")"}
+		if len(ruleDef.DropOptions.TokenParseOptions) > 0 {
+			lines = append(lines, "drop "+tokenOptionsLabel(ruleDef.DropOptions))
+		}
+		fmt.Fprintf(w, "  %s [shape=%s, label=%s];\n", id, shape, dotQuote(lines...))
+		for i, ruleField := range ruleDef.Fields {
+			childID, ok := ids[ruleField.BaseType]
+			if !ok {
+				childID = fmt.Sprintf("%s_%d", id, i)
+				label := "empty"
+				if len(ruleField.TokenParseOptions) > 0 {
+					label = tokenOptionsLabel(ruleField.TokenOptions)
+				}
+				fmt.Fprintf(w, "  %s [shape=ellipse, label=%s];\n", childID, dotQuote(label))
+			}
+			label := ruleField.Name
+			switch ruleField.Lookahead {
+			case AndLookahead:
+				label = "&" + label
+			case NotLookahead:
+				label = "!" + label
+			}
+			if !ruleDef.OneOf {
+				label = fmt.Sprintf("%d. %s", i+1, label)
+			}
+			var style string
+			switch {
+			case ruleField.Lookahead != NoLookahead:
+				style = ", style=dotted"
+			case ruleField.Pointer:
+				label += "?"
+				style = ", style=dashed"
+			case ruleField.Array:
+				label += repetitionLabel(ruleField.SizeOptions)
+				if ruleField.hasSep() {
+					if ruleField.SepRule != nil {
+						label += " sep @" + ruleTypeName(ruleField.SepRule)
+					} else {
+						label += " sep " + tokenOptionsLabel(ruleField.SepOptions)
+					}
+					label += sepPolicyLabel("leading", ruleField.Leading)
+					label += sepPolicyLabel("trailing", ruleField.Trailing)
+				}
+				style = ", style=bold"
+			}
+			fmt.Fprintf(w, "  %s -> %s [label=%s%s];\n", id, childID, dotQuote(label), style)
+		}
+	}
+	fmt.Fprintln(w, "}")
+	return w.Flush()
+}
+
+// sepPolicyLabel describes a separator policy which is not the default, e.g.
+// " trailing:allow".
+func sepPolicyLabel(name string, policy SepPolicy) string {
+	switch policy {
+	case SepAllow:
+		return " " + name + ":allow"
+	case SepRequire:
+		return " " + name + ":require"
+	default:
+		return ""
+	}
+}
+
+func repetitionLabel(opts SizeOptions) string {
+	switch {
+	case opts.Max == 0 && opts.Min == 0:
+		return "*"
+	case opts.Max == 0 && opts.Min == 1:
+		return "+"
+	case opts.Max == 0:
+		return fmt.Sprintf("{%d,}", opts.Min)
+	case opts.Min == opts.Max:
+		return fmt.Sprintf("{%d}", opts.Min)
+	default:
+		return fmt.Sprintf("{%d,%d}", opts.Min, opts.Max)
+	}
+}
+
+// tokenOptionsLabel returns a compact description of token options, e.g.
+// `op "[" | string`.  Options from a token class are described by the name of
+// the class.
+func tokenOptionsLabel(opts TokenOptions) string {
+	var parts []string
+	seenClasses := map[string]struct{}{}
+	for _, opt := range opts.TokenParseOptions {
+		if opt.Class != "" {
+			if _, ok := seenClasses[opt.Class]; !ok {
+				seenClasses[opt.Class] = struct{}{}
+				parts = append(parts, opt.Class)
+			}
+			continue
+		}
+		var part []string
+		if opt.TokenType != "" {
+			part = append(part, opt.TokenType)
+		}
+		if label := opt.valueLabel(); label != "" {
+			part = append(part, label)
+		}
+		if len(part) == 0 {
+			part = append(part, "any")
+		}
+		parts = append(parts, strings.Join(part, " "))
+	}
+	return strings.Join(parts, " | ")
+}
+
+// dotQuote returns a DOT string literal with one line for each argument.
+func dotQuote(lines ...string) string {
+	escaped := make([]string, len(lines))
+	for i, line := range lines {
+		line = strings.ReplaceAll(line, `\`, `\\`)
+		escaped[i] = strings.ReplaceAll(line, `"`, `\"`)
+	}
+	return `"` + strings.Join(escaped, `\n`) + `"`
+}
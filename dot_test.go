@@ -0,0 +1,86 @@
+package grammar
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDotWriteTree(t *testing.T) {
+	expr := mustParseTestExpr(t, "(a 1)")
+	var b strings.Builder
+	if err := DotWriteTree(&b, expr); err != nil {
+		t.Fatal(err)
+	}
+	want := `digraph tree {
+  node [fontname=monospace];
+  n0 [shape=box, label="testExpr"];
+  n1 [shape=box, label="testList"];
+  n2 [shape=box, label="testExpr"];
+  n3 [shape=ellipse, label="atom\n\"a\""];
+  n2 -> n3 [label="Atom"];
+  n1 -> n2 [label="Items[0]"];
+  n4 [shape=box, label="testExpr"];
+  n5 [shape=ellipse, label="number\n\"1\""];
+  n4 -> n5 [label="Number"];
+  n1 -> n4 [label="Items[1]"];
+  n0 -> n1 [label="List"];
+}
+`
+	if b.String() != want {
+		t.Errorf("Got:\n%s\nWant:\n%s", b.String(), want)
+	}
+}
+
+func TestDotWriteRuleGraph(t *testing.T) {
+	type Item struct {
+		Seq
+		Value SimpleToken `tok:"int"`
+	}
+	type Items struct {
+		Seq   `drop:"nl"`
+		Items []Item       `sep:"op,," size:"1-"`
+		End   *SimpleToken `tok:"op,;"`
+	}
+	var b strings.Builder
+	if err := DotWriteRuleGraph(&b, testExpr{}); err != nil {
+		t.Fatal(err)
+	}
+	want := `digraph rules {
+  node [fontname=monospace];
+  r0 [shape=diamond, label="testExpr\n(one of)"];
+  r0_0 [shape=ellipse, label="number"];
+  r0 -> r0_0 [label="Number?", style=dashed];
+  r0_1 [shape=ellipse, label="atom"];
+  r0 -> r0_1 [label="Atom?", style=dashed];
+  r0 -> r1 [label="List?", style=dashed];
+  r1 [shape=box, label="testList\n(seq)"];
+  r1_0 [shape=ellipse, label="bkt \"(\""];
+  r1 -> r1_0 [label="1. Open"];
+  r1 -> r0 [label="2. Items*", style=bold];
+  r1_2 [shape=ellipse, label="bkt \")\""];
+  r1 -> r1_2 [label="3. Close"];
+}
+`
+	if b.String() != want {
+		t.Errorf("Got:\n%s\nWant:\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	if err := DotWriteRuleGraph(&b, &Items{}); err != nil {
+		t.Fatal(err)
+	}
+	want = `digraph rules {
+  node [fontname=monospace];
+  r0 [shape=box, label="Items\n(seq)\ndrop nl"];
+  r0 -> r1 [label="1. Items+ sep op \",\"", style=bold];
+  r0_1 [shape=ellipse, label="op \";\""];
+  r0 -> r0_1 [label="2. End?", style=dashed];
+  r1 [shape=box, label="Item\n(seq)"];
+  r1_0 [shape=ellipse, label="int"];
+  r1 -> r1_0 [label="1. Value"];
+}
+`
+	if b.String() != want {
+		t.Errorf("Got:\n%s\nWant:\n%s", b.String(), want)
+	}
+}
@@ -0,0 +1,109 @@
+package grammar
+
+import "reflect"
+
+// WithIterativeEngine makes the parser keep track of the Seq and OneOf rules
+// being parsed in a stack allocated on the heap rather than by making
+// recursive calls, so that inputs which are nested very deeply can be parsed
+// without exhausting the goroutine stack.  The result is the same as with the
+// default recursive engine.
+//
+// Rule types implementing CustomParser, as well as other types implementing
+// Parser (such as tokens), are parsed by calling their Parse method, as the
+// recursive engine does.  Other rule types are parsed according to their
+// definition, even if they declare their own Parse method.
+func WithIterativeEngine() ParseOption {
+	return func(s *ParserState) {
+		s.iterative = true
+	}
+}
+
+// A CustomParser is a rule type which declares its own Parse method rather
+// than using the one of Seq, OneOf or Longest, e.g. to post-process the parsed
+// rule.  The CustomParse method is only a marker telling the iterative engine
+// to call the Parse method of the rule.
+//
+//	func (r *Upper) Parse(p interface{}, s *grammar.ParserState, opts grammar.TokenOptions) *grammar.ParseError {
+//	    if err := r.Seq.Parse(p, s, opts); err != nil {
+//	        return err
+//	    }
+//	    r.Name.TokValue = strings.ToUpper(r.Name.TokValue)
+//	    return nil
+//	}
+//
+//	func (r *Upper) CustomParse() {}
+type CustomParser interface {
+	Parser
+	CustomParse()
+}
+
+var customParserType = reflect.TypeOf((*CustomParser)(nil)).Elem()
+
+// isRule returns true if dest is a pointer to a Seq or OneOf rule which does
+// not implement CustomParser.
+func isRule(dest interface{}) bool {
+	tp := reflect.TypeOf(dest)
+	if tp.Kind() != reflect.Ptr {
+		return false
+	}
+	ruleDef, err := getRuleDef(tp.Elem())
+	return err == nil && !ruleDef.customParse
+}
+
+// An engineFrame is an item in the stack of the iterative engine.
+type engineFrame struct {
+	*ruleFrame
+	dest  interface{}
+	rule  string // The rule name (if tracing is enabled)
+	start int    // The start position of the rule (if spans are recorded)
+}
+
+// parseIterative parses the rule dest using an explicit stack, doing what
+// ParseWithOptions, parseField and ruleFrame.run do in the recursive engine.
+func (s *ParserState) parseIterative(dest interface{}, opts TokenOptions) *ParseError {
+	rule, err := s.enterRule(dest, opts)
+	if err != nil {
+		return err
+	}
+	stack := []engineFrame{{ruleFrame: newRuleFrame(dest), dest: dest, rule: rule}}
+	var childErr *ParseError
+	for {
+		top := stack[len(stack)-1]
+		more, err := top.next(s, childErr)
+		if more {
+			child := top.child.Interface()
+			ruleField := top.childField
+			var start int
+			if s.spans != nil {
+				start = s.enterField(top.ruleDef, ruleField, top.childIndex)
+			}
+			if !isRule(child) {
+				childErr = ParseWithOptions(child, s, ruleField.TokenOptions)
+				if s.spans != nil {
+					s.exitField(start, childErr)
+				}
+				continue
+			}
+			rule, err := s.enterRule(child, ruleField.TokenOptions)
+			if err != nil {
+				if s.spans != nil {
+					s.exitField(start, err)
+				}
+				childErr = err
+				continue
+			}
+			stack = append(stack, engineFrame{ruleFrame: newRuleFrame(child), dest: child, rule: rule, start: start})
+			childErr = nil
+			continue
+		}
+		s.exitRule(top.dest, top.rule, err)
+		stack = stack[:len(stack)-1]
+		if len(stack) == 0 {
+			return err
+		}
+		if s.spans != nil {
+			s.exitField(top.start, err)
+		}
+		childErr = err
+	}
+}
@@ -0,0 +1,149 @@
+package grammar
+
+import (
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestIterativeEngine(t *testing.T) {
+	srcs := []string{"1", "(a (1) ())", "(a (1)", "(a))", ")"}
+	for _, src := range srcs {
+		var recEvents, itEvents recordingTracer
+		recExpr, recErr := parseTestExprWith(t, src, WithTracer(&recEvents))
+		itExpr, itErr := parseTestExprWith(t, src, WithTracer(&itEvents), WithIterativeEngine())
+		if !reflect.DeepEqual(recExpr, itExpr) {
+			t.Errorf("Different trees for %q", src)
+		}
+		if !reflect.DeepEqual(recErr, itErr) {
+			t.Errorf("Different errors for %q: %v and %v", src, recErr, itErr)
+		}
+		if !reflect.DeepEqual(recEvents, itEvents) {
+			t.Errorf("Different trace events for %q", src)
+		}
+	}
+}
+
+func TestIterativeEngineDeep(t *testing.T) {
+	const depth = 20000
+	src := strings.Repeat("(", depth) + "1" + strings.Repeat(")", depth)
+	// The recursive engine would need a much larger stack for this.
+	defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+	expr, err := parseTestExprWith(t, src, WithIterativeEngine())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for expr.List != nil {
+		expr = &expr.List.Items[0]
+		n++
+	}
+	if n != depth || expr.Number == nil {
+		t.Errorf("Wrong tree: depth %d", n)
+	}
+}
+
+// ceUpper has a custom Parse method, which upper-cases the atom.
+type ceUpper struct {
+	Seq
+	Atom SimpleToken `tok:"atom"`
+	Expr testExpr
+}
+
+func (u *ceUpper) Parse(r interface{}, s *ParserState, opts TokenOptions) *ParseError {
+	if err := u.Seq.Parse(r, s, opts); err != nil {
+		return err
+	}
+	u.Atom.TokValue = strings.ToUpper(u.Atom.TokValue)
+	return nil
+}
+
+func (u *ceUpper) CustomParse() {}
+
+// ceUnmarked does not implement CustomParser, so the iterative engine does not
+// call its Parse method.
+type ceUnmarked struct {
+	Seq
+	Atom SimpleToken `tok:"atom"`
+}
+
+func (u *ceUnmarked) Parse(r interface{}, s *ParserState, opts TokenOptions) *ParseError {
+	if err := u.Seq.Parse(r, s, opts); err != nil {
+		return err
+	}
+	u.Atom.TokValue = strings.ToUpper(u.Atom.TokValue)
+	return nil
+}
+
+type ceUnmarkedProgram struct {
+	Seq
+	Item ceUnmarked
+}
+
+type ceProgram struct {
+	Seq
+	Items []ceUpper
+	End   Match `tok:"EOF"`
+}
+
+func TestIterativeEngineCustomParse(t *testing.T) {
+	var trees []ceProgram
+	for i, opts := range [][]ParseOption{nil, {WithIterativeEngine()}} {
+		stream, err := testTokenise("a 1 b (c (2))")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var p ceProgram
+		if err := Parse(&p, stream, opts...); err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Items) != 2 || p.Items[0].Atom.Value() != "A" || p.Items[1].Atom.Value() != "B" {
+			t.Errorf("Parse method not called by engine %d: %+v", i, p)
+		}
+		trees = append(trees, p)
+	}
+	if !reflect.DeepEqual(trees[0], trees[1]) {
+		t.Errorf("Different trees")
+	}
+
+	// Without the marker, only the recursive engine calls the Parse method.
+	for i, test := range []struct {
+		opts []ParseOption
+		want string
+	}{{nil, "A"}, {[]ParseOption{WithIterativeEngine()}, "a"}} {
+		stream, err := testTokenise("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var p ceUnmarkedProgram
+		if err := Parse(&p, stream, test.opts...); err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Item.Atom.Value(); got != test.want {
+			t.Errorf("Engine %d: got %q, want %q", i, got, test.want)
+		}
+	}
+	for _, test := range []struct {
+		rule interface{}
+		want bool
+	}{{ceUpper{}, true}, {ceUnmarked{}, false}, {testExpr{}, false}} {
+		ruleDef, err := getRuleDef(reflect.TypeOf(test.rule))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ruleDef.customParse != test.want {
+			t.Errorf("%T: got customParse %t, want %t", test.rule, ruleDef.customParse, test.want)
+		}
+	}
+}
+
+func parseTestExprWith(t *testing.T, src string, opts ...ParseOption) (*testExpr, *ParseError) {
+	t.Helper()
+	stream, err := testTokenise(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expr testExpr
+	return &expr, Parse(&expr, stream, opts...)
+}
@@ -0,0 +1,11 @@
+package json
+
+import (
+	"testing"
+
+	"github.com/arnodel/grammar/grammartest"
+)
+
+func FuzzJson(f *testing.F) {
+	grammartest.Fuzz(f, testConfig)
+}
@@ -0,0 +1,28 @@
+package json
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/arnodel/grammar"
+	"github.com/arnodel/grammar/grammartest"
+)
+
+var testConfig = grammartest.Config{
+	Tokenise:      grammartest.SimpleTokeniser(TokeniseJsonString),
+	Root:          Json{},
+	PrettyOptions: grammar.PrettyOptions{HideMatches: true},
+	Seeds:         []string{`{"a": [1, {}, null, false]}`},
+}
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestGolden(t *testing.T) {
+	cfg := testConfig
+	cfg.Update = *update
+	grammartest.RunGolden(t, cfg)
+}
+
+func TestEngines(t *testing.T) {
+	grammartest.RunEngines(t, testConfig)
+}
@@ -0,0 +1,29 @@
+package sexpr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arnodel/grammar/grammartest"
+)
+
+var testConfig = grammartest.Config{
+	Tokenise: grammartest.SimpleTokeniser(tokenise),
+	Root:     SExpr{},
+	Seeds: []string{
+		`(cons a (list 123 "c"))`,
+		`()`,
+		`(a (b (c)) "d" -1.5)`,
+		`(a (b "c"`,
+		`(a)) b`,
+		strings.Repeat("(x ", 200) + strings.Repeat(")", 200),
+	},
+}
+
+func FuzzSExpr(f *testing.F) {
+	grammartest.Fuzz(f, testConfig)
+}
+
+func TestEngines(t *testing.T) {
+	grammartest.RunEngines(t, testConfig)
+}
@@ -0,0 +1,327 @@
+package grammar
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+)
+
+// GenerateOptions control the random token streams produced by Generate.
+type GenerateOptions struct {
+	// Beyond this depth of nested rules, generation only picks the choices
+	// that lead to the smallest trees so that it terminates (default 10).
+	MaxDepth int
+
+	// Maximum number of items generated for repeated fields, unless their
+	// size tag requires more (default 3).
+	MaxRepeat int
+
+	// Probability that an optional field in a sequence rule is generated
+	// (default 0.5).
+	OptionalProbability float64
+
+	// Relative weights of the alternatives of one-of rules, keyed by
+	// "Rule.Field".  The default weight is 1, a weight of 0 disables the
+	// alternative (unless it is required to terminate).
+	Weights map[string]float64
+
+	// Functions returning random values for token types, used when the tok
+	// tag of a field does not specify a value.
+	TokenValues map[string]func(*rand.Rand) string
+
+	// Used by GenerateText to turn tokens into text (by default the value of
+	// the token is used).
+	TokenText func(Token) string
+
+	// Used by GenerateText to separate tokens (by default a space).
+	Separator string
+}
+
+// Generate returns a random sequence of tokens which matches the rule type
+// rootType.  Choices are made according to the structure of the rules and
+// their tok, sep and size tags, but note that because one-of rules are ordered
+// and repetitions are greedy, the tokens may not always parse back to a tree
+// with the same shape.  Tokens specified with the "*" (do not consume) suffix
+// and lookahead fields are not generated, so negative lookaheads may not hold.
+func Generate(rootType reflect.Type, rnd *rand.Rand, opts GenerateOptions) ([]Token, error) {
+	if opts.MaxDepth == 0 {
+		opts.MaxDepth = 10
+	}
+	if opts.MaxRepeat == 0 {
+		opts.MaxRepeat = 3
+	}
+	if opts.OptionalProbability == 0 {
+		opts.OptionalProbability = 0.5
+	}
+	ruleDefs, err := reachableRuleDefs(rootType)
+	if err != nil {
+		return nil, err
+	}
+	g := generator{
+		rnd:       rnd,
+		opts:      opts,
+		minHeight: minRuleHeights(ruleDefs),
+	}
+	if math.IsInf(g.minHeight[rootType], 1) {
+		return nil, fmt.Errorf("rule %s cannot generate a finite token stream", rootType)
+	}
+	err = g.generate(rootType, TokenOptions{}, 0)
+	return g.tokens, err
+}
+
+// GenerateText is like Generate but returns the text made of the generated
+// tokens, using opts.TokenText and opts.Separator.
+func GenerateText(rootType reflect.Type, rnd *rand.Rand, opts GenerateOptions) (string, error) {
+	toks, err := Generate(rootType, rnd, opts)
+	if err != nil {
+		return "", err
+	}
+	sep := opts.Separator
+	if sep == "" {
+		sep = " "
+	}
+	parts := make([]string, len(toks))
+	for i, tok := range toks {
+		if opts.TokenText != nil {
+			parts[i] = opts.TokenText(tok)
+		} else {
+			parts[i] = tok.Value()
+		}
+	}
+	return strings.Join(parts, sep), nil
+}
+
+// minRuleHeights computes the minimum height of a tree for each rule (tokens
+// have height 0).  Rules which cannot produce a finite tree have an infinite
+// height.
+func minRuleHeights(ruleDefs []reachableRuleDef) map[reflect.Type]float64 {
+	heights := map[reflect.Type]float64{}
+	for _, ruleDef := range ruleDefs {
+		heights[ruleDef.Type] = math.Inf(1)
+	}
+	height := func(tp reflect.Type) float64 {
+		if h, ok := heights[tp]; ok {
+			return h
+		}
+		return 0
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, ruleDef := range ruleDefs {
+			var h float64
+			if ruleDef.OneOf {
+				h = math.Inf(1)
+				for _, ruleField := range ruleDef.Fields {
+					h = math.Min(h, height(ruleField.BaseType))
+				}
+			} else {
+				for _, ruleField := range ruleDef.Fields {
+					if ruleField.isRequired() {
+						h = math.Max(h, height(ruleField.BaseType))
+					}
+				}
+			}
+			if h+1 < heights[ruleDef.Type] {
+				heights[ruleDef.Type] = h + 1
+				changed = true
+			}
+		}
+	}
+	return heights
+}
+
+// isRequired returns true if the field has to match at least once in a
+// sequence.
+func (f RuleField) isRequired() bool {
+	return !f.Pointer && (!f.Array || f.Min > 0) && f.Lookahead == NoLookahead
+}
+
+type generator struct {
+	rnd       *rand.Rand
+	opts      GenerateOptions
+	minHeight map[reflect.Type]float64
+	tokens    []Token
+}
+
+func (g *generator) generate(tp reflect.Type, tokOpts TokenOptions, depth int) error {
+	ruleDef, err := getRuleDef(tp)
+	if err != nil {
+		return g.generateToken(tokOpts)
+	}
+	minimal := depth >= g.opts.MaxDepth
+	if ruleDef.OneOf {
+		ruleField, err := g.chooseAlternative(ruleDef, minimal)
+		if err != nil {
+			return err
+		}
+		if ruleField.Array {
+			min := ruleField.Min
+			if min == 0 {
+				min = 1
+			}
+			return g.generateItems(ruleField, min, depth, minimal)
+		}
+		return g.generate(ruleField.BaseType, ruleField.TokenOptions, depth+1)
+	}
+	// In a sequence at least one field must match, so if there are no
+	// required fields, one of the optional ones is forced.
+	forced := -1
+	hasRequired := false
+	for _, ruleField := range ruleDef.Fields {
+		hasRequired = hasRequired || ruleField.isRequired()
+	}
+	if !hasRequired {
+		for i, ruleField := range ruleDef.Fields {
+			if ruleField.Lookahead != NoLookahead {
+				continue
+			}
+			if forced < 0 || g.height(ruleField) < g.height(ruleDef.Fields[forced]) {
+				forced = i
+			}
+		}
+	}
+	for i, ruleField := range ruleDef.Fields {
+		switch {
+		case ruleField.Lookahead != NoLookahead:
+			// Lookahead fields do not generate tokens.
+		case ruleField.Pointer:
+			if i == forced || !minimal && g.rnd.Float64() < g.opts.OptionalProbability {
+				err = g.generate(ruleField.BaseType, ruleField.TokenOptions, depth+1)
+			}
+		case ruleField.Array:
+			min := ruleField.Min
+			if i == forced && min == 0 {
+				min = 1
+			}
+			err = g.generateItems(ruleField, min, depth, minimal)
+		default:
+			err = g.generate(ruleField.BaseType, ruleField.TokenOptions, depth+1)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *generator) height(ruleField RuleField) float64 {
+	return g.minHeight[ruleField.BaseType]
+}
+
+// chooseAlternative chooses a field of a one-of rule at random according to
+// the weights, or one with the smallest height if minimal is true.
+func (g *generator) chooseAlternative(ruleDef *RuleDef, minimal bool) (RuleField, error) {
+	var candidates []RuleField
+	var weights []float64
+	var total float64
+	best := math.Inf(1)
+	for _, ruleField := range ruleDef.Fields {
+		h := g.height(ruleField)
+		if math.IsInf(h, 1) {
+			continue
+		}
+		if minimal {
+			if h < best {
+				best = h
+				candidates = candidates[:0]
+			}
+			if h == best {
+				candidates = append(candidates, ruleField)
+			}
+			continue
+		}
+		w := 1.0
+		if fw, ok := g.opts.Weights[ruleDef.Name+"."+ruleField.Name]; ok {
+			w = fw
+		}
+		if w > 0 {
+			candidates = append(candidates, ruleField)
+			weights = append(weights, w)
+			total += w
+		}
+	}
+	switch {
+	case len(candidates) == 0 && minimal:
+		return RuleField{}, fmt.Errorf("rule %s cannot generate a finite token stream", ruleDef.Name)
+	case len(candidates) == 0:
+		return g.chooseAlternative(ruleDef, true)
+	case minimal:
+		return candidates[g.rnd.Intn(len(candidates))], nil
+	}
+	x := g.rnd.Float64() * total
+	for i, w := range weights {
+		if x < w {
+			return candidates[i], nil
+		}
+		x -= w
+	}
+	return candidates[len(candidates)-1], nil
+}
+
+// generateItems generates items for a repeated field, separated by the
+// separator token if there is one.
+func (g *generator) generateItems(ruleField RuleField, min int, depth int, minimal bool) error {
+	n := min
+	if !minimal {
+		hi := g.opts.MaxRepeat
+		if ruleField.Max > 0 && hi > ruleField.Max {
+			hi = ruleField.Max
+		}
+		if hi < min {
+			hi = min
+		}
+		n += g.rnd.Intn(hi - min + 1)
+	}
+	if n > 0 && ruleField.Leading == SepRequire {
+		if err := g.generateSep(ruleField, depth); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			if err := g.generateSep(ruleField, depth); err != nil {
+				return err
+			}
+		}
+		if err := g.generate(ruleField.BaseType, ruleField.TokenOptions, depth+1); err != nil {
+			return err
+		}
+	}
+	if n > 0 && ruleField.Trailing == SepRequire {
+		return g.generateSep(ruleField, depth)
+	}
+	return nil
+}
+
+// generateSep appends a separator for the items of a repeated field.
+func (g *generator) generateSep(ruleField RuleField, depth int) error {
+	if ruleField.SepRule != nil {
+		return g.generate(ruleField.SepRule, TokenOptions{}, depth+1)
+	}
+	return g.generateToken(ruleField.SepOptions)
+}
+
+// generateToken appends a token matching one of the token options.  If there
+// are no options, nothing is generated as the parser does not consume a token
+// in this case.
+func (g *generator) generateToken(tokOpts TokenOptions) error {
+	if len(tokOpts.TokenParseOptions) == 0 {
+		return nil
+	}
+	opt := tokOpts.TokenParseOptions[g.rnd.Intn(len(tokOpts.TokenParseOptions))]
+	if opt.DoNotConsume {
+		return nil
+	}
+	value := opt.TokenValue
+	if value == "" {
+		gen, ok := g.opts.TokenValues[opt.TokenType]
+		if !ok {
+			return fmt.Errorf("no value generator for token type %q", opt.TokenType)
+		}
+		value = gen(g.rnd)
+	}
+	g.tokens = append(g.tokens, SimpleToken{TokType: opt.TokenType, TokValue: value})
+	return nil
+}
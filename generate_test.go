@@ -0,0 +1,77 @@
+package grammar
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	type Item struct {
+		Seq
+		Key   *SimpleToken `tok:"kw,let"`
+		Value []testExpr   `size:"1-2"`
+	}
+	type Items struct {
+		Seq
+		Items []Item `sep:"op,;"`
+		End   Match  `tok:"op,."`
+	}
+	opts := GenerateOptions{
+		MaxDepth: 5,
+		Weights:  map[string]float64{"testExpr.List": 3},
+		TokenValues: map[string]func(*rand.Rand) string{
+			"atom": func(r *rand.Rand) string {
+				return string(rune('a' + r.Intn(26)))
+			},
+			"number": func(r *rand.Rand) string {
+				return strconv.Itoa(r.Intn(100))
+			},
+		},
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for _, tp := range []reflect.Type{reflect.TypeOf(testExpr{}), reflect.TypeOf(Items{})} {
+		for i := 0; i < 100; i++ {
+			toks, err := Generate(tp, rnd, opts)
+			if err != nil {
+				t.Fatalf("Error generating %s: %s", tp, err)
+			}
+			stream := NewSimpleTokenStream(toks)
+			if err := Parse(reflect.New(tp).Interface(), stream); err != nil {
+				t.Fatalf("Error parsing generated tokens %v: %s", toks, err)
+			}
+			if tok := stream.Next(); tok != EOF {
+				t.Fatalf("Generated tokens %v not all consumed", toks)
+			}
+		}
+	}
+}
+
+func TestGenerateText(t *testing.T) {
+	text, err := GenerateText(reflect.TypeOf(testExpr{}), rand.New(rand.NewSource(1)), GenerateOptions{
+		MaxDepth:  1,
+		Weights:   map[string]float64{"testExpr.Atom": 0, "testExpr.Number": 0},
+		TokenText: func(tok Token) string { return "<" + tok.Value() + ">" },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "<(> <)>" {
+		t.Errorf("Unexpected text %q", text)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	type Loop struct {
+		Seq
+		Next *Loop
+		Self []Loop `size:"1-"`
+	}
+	if _, err := Generate(reflect.TypeOf(Loop{}), rand.New(rand.NewSource(1)), GenerateOptions{}); err == nil {
+		t.Error("Expected an error for a rule that cannot terminate")
+	}
+	if _, err := Generate(reflect.TypeOf(testExpr{}), rand.New(rand.NewSource(1)), GenerateOptions{}); err == nil {
+		t.Error("Expected an error for missing token value generators")
+	}
+}
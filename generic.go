@@ -0,0 +1,117 @@
+package grammar
+
+import (
+	"reflect"
+	"strings"
+)
+
+// The generic rules below are building blocks for common shapes in grammars.
+// Their type arguments must be rules, so to use a token as the opening
+// bracket of a Delimited rule, define a rule that matches just that token,
+// e.g.
+//
+//	type OpenBkt struct {
+//	    grammar.Seq
+//	    Tok grammar.Match `tok:"op,["`
+//	}
+//
+//	type Array struct {
+//	    grammar.Seq
+//	    Items grammar.Delimited[OpenBkt, Value, Comma, CloseBkt]
+//	}
+
+// Optional is a rule which matches T or nothing.  Value is nil if T did not
+// match.
+type Optional[T any] struct {
+	Seq
+	Value *T
+}
+
+func (Optional[T]) allowEmpty() {}
+
+// SepBy is a rule which matches zero or more T separated by Sep.  A separator
+// must be followed by an item.
+type SepBy[T, Sep any] struct {
+	Seq
+	First *T
+	Rest  []SepItem[T, Sep]
+}
+
+func (SepBy[T, Sep]) allowEmpty() {}
+
+// Items returns all the items matched.
+func (l SepBy[T, Sep]) Items() []T {
+	if l.First == nil {
+		return nil
+	}
+	items := make([]T, 0, len(l.Rest)+1)
+	items = append(items, *l.First)
+	for _, item := range l.Rest {
+		items = append(items, item.Item)
+	}
+	return items
+}
+
+// SepItem is an item of a SepBy rule after the first one, with the separator
+// that precedes it.
+type SepItem[T, Sep any] struct {
+	Seq
+	Sep  Sep
+	Item T
+}
+
+// Delimited is a rule which matches Open, then zero or more T separated by
+// Sep, then Close.
+type Delimited[Open, T, Sep, Close any] struct {
+	Seq
+	Open  Open
+	List  SepBy[T, Sep]
+	Close Close
+}
+
+// Items returns all the items matched.
+func (d Delimited[Open, T, Sep, Close]) Items() []T {
+	return d.List.Items()
+}
+
+// A rule implementing emptyAllower is allowed to match no tokens (normally a
+// Seq rule fails when none of its fields match anything).
+type emptyAllower interface {
+	allowEmpty()
+}
+
+var emptyAllowerType = reflect.TypeOf((*emptyAllower)(nil)).Elem()
+
+// ruleTypeName returns the name of a rule type.  For instances of generic
+// types, the package paths of the type arguments are omitted, e.g.
+// "Optional[Value]" rather than "Optional[github.com/x/y.Value]".
+func ruleTypeName(tp reflect.Type) string {
+	return qualifyTypeNames(tp.Name(), func(string) string { return "" })
+}
+
+// qualifyTypeNames replaces each package path in a type name as returned by
+// reflect with the result of qualify, which should be empty or end in ".".
+func qualifyTypeNames(name string, qualify func(pkgPath string) string) string {
+	if !strings.ContainsAny(name, "./") {
+		return name
+	}
+	var b strings.Builder
+	for len(name) > 0 {
+		i := strings.IndexAny(name, "[],* ")
+		if i == 0 {
+			b.WriteByte(name[0])
+			name = name[1:]
+			continue
+		}
+		if i < 0 {
+			i = len(name)
+		}
+		ident := name[:i]
+		if j := strings.LastIndexByte(ident, '.'); j >= 0 {
+			ident = qualify(ident[:j]) + ident[j+1:]
+		}
+		b.WriteString(ident)
+		name = name[i:]
+	}
+	return b.String()
+}
@@ -0,0 +1,121 @@
+package grammar
+
+import (
+	"reflect"
+	"testing"
+)
+
+type gnOpen struct {
+	Seq
+	Tok Match `tok:"op,["`
+}
+
+type gnClose struct {
+	Seq
+	Tok Match `tok:"op,]"`
+}
+
+type gnComma struct {
+	Seq
+	Tok Match `tok:"op,,"`
+}
+
+type gnMinus struct {
+	Seq
+	Tok Match `tok:"op,-"`
+}
+
+type gnValue struct {
+	OneOf
+	Number *gnNumber
+	Array  *gnArray
+}
+
+type gnNumber struct {
+	Seq
+	Minus  Optional[gnMinus]
+	Digits SimpleToken `tok:"number"`
+}
+
+type gnArray = Delimited[gnOpen, gnValue, gnComma, gnClose]
+
+func TestGenericRules(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{
+			src:  "[]",
+			want: "gnValue {Array: Delimited[gnOpen,gnValue,gnComma,gnClose] {Open: gnOpen {}, List: SepBy[gnValue,gnComma] {}, Close: gnClose {}}} @2",
+		},
+		{
+			src:  "[-1]",
+			want: "gnValue {Array: Delimited[gnOpen,gnValue,gnComma,gnClose] {Open: gnOpen {}, List: SepBy[gnValue,gnComma] {First: gnValue {Number: gnNumber {Minus: Optional[gnMinus] {Value: gnMinus {}}, Digits: {number 1}}}}, Close: gnClose {}}} @4",
+		},
+		{
+			src:  "[1, [2]]",
+			want: "gnValue {Array: Delimited[gnOpen,gnValue,gnComma,gnClose] {Open: gnOpen {}, List: SepBy[gnValue,gnComma] {First: gnValue {Number: gnNumber {Minus: Optional[gnMinus] {}, Digits: {number 1}}}, Rest: [SepItem[gnValue,gnComma] {Sep: gnComma {}, Item: gnValue {Array: Delimited[gnOpen,gnValue,gnComma,gnClose] {Open: gnOpen {}, List: SepBy[gnValue,gnComma] {First: gnValue {Number: gnNumber {Minus: Optional[gnMinus] {}, Digits: {number 2}}}}, Close: gnClose {}}}}]}, Close: gnClose {}}} @7",
+		},
+		{
+			src:  "[1,]",
+			want: `error: token #3 op with value "]": expected token with type number, or value "-" or "["`,
+		},
+		{
+			src:  "[1 2]",
+			want: `error: token #2 number with value "2": expected token with value "," or "]"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.src, func(t *testing.T) {
+			res := parseBothEngines(t, &gnValue{}, test.src)
+			got := res.format(PrettyOptions{Compact: true, HideMatches: true})
+			if got != test.want {
+				t.Errorf("Got:\n%s\nWant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGenericItems(t *testing.T) {
+	stream, err := testLangTokenise("[1, 2, 3]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var arr gnArray
+	if err := Parse(&arr, stream); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, item := range arr.Items() {
+		got = append(got, item.Number.Digits.Value())
+	}
+	if want := []string{"1", "2", "3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+	if items := (SepBy[gnValue, gnComma]{}).Items(); items != nil {
+		t.Errorf("Got %v, want no items", items)
+	}
+}
+
+func TestQualifyTypeNames(t *testing.T) {
+	qualify := func(pkgPath string) string {
+		if pkgPath == "main" {
+			return ""
+		}
+		return "q."
+	}
+	tests := []struct {
+		name, want string
+	}{
+		{"Value", "Value"},
+		{"Optional[main.Value]", "Optional[Value]"},
+		{"SepBy[github.com/x/y.Value,*gopkg.in/z.v2.Sep]", "SepBy[q.Value,*q.Sep]"},
+		{"Optional[[]main.Value]", "Optional[[]Value]"},
+		{"Pair[int,map[string]main.Value]", "Pair[int,map[string]Value]"},
+	}
+	for _, test := range tests {
+		if got := qualifyTypeNames(test.name, qualify); got != test.want {
+			t.Errorf("qualifyTypeNames(%q): got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
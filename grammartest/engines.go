@@ -0,0 +1,89 @@
+package grammartest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/arnodel/grammar"
+)
+
+// RunEngines calls CompareEngines on each input of cfg: the contents of the
+// ".input" files in the configured directory (if it exists) and cfg.Seeds.
+// Each input is run as a subtest.
+func RunEngines(t *testing.T, cfg Config) {
+	t.Helper()
+	inputs, err := inputFiles(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range inputs {
+		input := input
+		t.Run(filepath.Base(input), func(t *testing.T) {
+			src, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			CompareEngines(t, cfg, string(src))
+		})
+	}
+	for i, seed := range cfg.Seeds {
+		seed := seed
+		t.Run(fmt.Sprintf("seed%d", i), func(t *testing.T) {
+			CompareEngines(t, cfg, seed)
+		})
+	}
+}
+
+// CompareEngines parses src with the default recursive engine and with the
+// iterative engine (see grammar.WithIterativeEngine), and fails the test if
+// the parse trees, the spans of their nodes, the errors or the positions
+// reached in the token stream are not the same.  Inputs which cannot be
+// tokenised are ignored.
+func CompareEngines(t *testing.T, cfg Config, src string) {
+	t.Helper()
+	if err := compareEngines(cfg, src); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// compareEngines performs the check of CompareEngines, returning an error if
+// it fails.
+func compareEngines(cfg Config, src string) error {
+	recursive, err := parseForComparison(cfg, src)
+	if err != nil {
+		return nil
+	}
+	iterative, _ := parseForComparison(cfg, src, grammar.WithIterativeEngine())
+	if !reflect.DeepEqual(recursive, iterative) {
+		return fmt.Errorf("engines differ on input %q\nRecursive: %+v\nIterative: %+v", src, recursive, iterative)
+	}
+	return nil
+}
+
+// engineResult is what is compared by CompareEngines.
+type engineResult struct {
+	Tree  interface{}
+	Spans grammar.Spans
+	Err   string
+	Pos   int
+}
+
+func parseForComparison(cfg Config, src string, opts ...grammar.ParseOption) (*engineResult, error) {
+	stream, err := cfg.Tokenise(src)
+	if err != nil {
+		return nil, err
+	}
+	res := &engineResult{Spans: grammar.Spans{}}
+	opts = append(append(opts, cfg.ParseOptions...), grammar.WithSpans(res.Spans))
+	destV := reflect.New(reflect.TypeOf(cfg.Root))
+	if parseErr := grammar.Parse(destV.Interface(), stream, opts...); parseErr != nil {
+		res.Err = parseErr.Error()
+	} else {
+		res.Tree = destV.Interface()
+	}
+	res.Pos = stream.Save()
+	return res, nil
+}
@@ -0,0 +1,111 @@
+package grammartest
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"runtime/debug"
+	"testing"
+
+	"github.com/arnodel/grammar"
+)
+
+// Fuzz runs a fuzz test of the grammar described by cfg, calling CheckInput
+// for each input.  The seed corpus is made of cfg.Seeds and the contents of
+// the ".input" files in the configured directory (if it exists), so the inputs
+// of golden tests are reused.
+func Fuzz(f *testing.F, cfg Config) {
+	inputs, err := inputFiles(cfg)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, input := range inputs {
+		src, err := os.ReadFile(input)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(src))
+	}
+	for _, seed := range cfg.Seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		CheckInput(t, cfg, src)
+	})
+}
+
+// CheckInput checks properties of the grammar for the input src: the parser
+// must not panic, and if it succeeds, the tokens obtained by unparsing the
+// tree (see grammar.Unparse) must parse to an identical tree.  Also the
+// iterative engine must give the same results as the recursive engine (see
+// CompareEngines).  Inputs which cannot be tokenised are ignored.
+func CheckInput(t *testing.T, cfg Config, src string) {
+	t.Helper()
+	if err := checkInput(cfg, src); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkInput performs the checks of CheckInput, returning an error describing
+// the first one which fails.
+func checkInput(cfg Config, src string) error {
+	stream, err := cfg.Tokenise(src)
+	if err != nil {
+		return nil
+	}
+	tree, parseErr := safeParse(cfg, stream)
+	if panicErr, ok := parseErr.(panicError); ok {
+		return fmt.Errorf("parser panicked on input %q: %v\n%s", src, panicErr.value, panicErr.stack)
+	}
+	if err := compareEngines(cfg, src); err != nil {
+		return err
+	}
+	if parseErr != nil {
+		return nil
+	}
+	if err := checkRoundTrip(cfg, tree); err != nil {
+		return fmt.Errorf("input %q: %w", src, err)
+	}
+	return nil
+}
+
+// checkRoundTrip checks that the tokens obtained by unparsing tree parse to an
+// identical tree.
+func checkRoundTrip(cfg Config, tree interface{}) error {
+	toks, err := grammar.Unparse(tree)
+	if err != nil {
+		return fmt.Errorf("cannot unparse tree: %w", err)
+	}
+	tree2, parseErr := safeParse(cfg, grammar.NewSimpleTokenStream(toks))
+	if parseErr != nil {
+		return fmt.Errorf("cannot parse unparsed tokens %v: %w", toks, parseErr)
+	}
+	if !reflect.DeepEqual(tree, tree2) {
+		return fmt.Errorf("parsing unparsed tokens gives a different tree\nTokens: %v", toks)
+	}
+	return nil
+}
+
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (e panicError) Error() string {
+	return "panic during parsing"
+}
+
+// safeParse parses the token stream according to the root rule, turning a
+// panic into a panicError.
+func safeParse(cfg Config, stream grammar.TokenStream) (tree interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError{value: r, stack: debug.Stack()}
+		}
+	}()
+	destV := reflect.New(reflect.TypeOf(cfg.Root))
+	if parseErr := grammar.Parse(destV.Interface(), stream, cfg.ParseOptions...); parseErr != nil {
+		return nil, parseErr
+	}
+	return destV.Interface(), nil
+}
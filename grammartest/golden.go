@@ -0,0 +1,132 @@
+// Package grammartest provides support for testing grammars defined with the
+// grammar package.
+package grammartest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/grammar"
+)
+
+// A Tokeniser turns a string into a token stream.
+type Tokeniser func(string) (grammar.TokenStream, error)
+
+// SimpleTokeniser adapts a tokeniser returned by grammar.SimpleTokeniser.
+func SimpleTokeniser(tokenise func(string) (*grammar.SimpleTokenStream, error)) Tokeniser {
+	return func(s string) (grammar.TokenStream, error) {
+		stream, err := tokenise(s)
+		if err != nil {
+			return nil, err
+		}
+		return stream, nil
+	}
+}
+
+// Config describes the grammar under test.
+type Config struct {
+	Tokenise      Tokeniser             // Used to tokenise inputs
+	Root          interface{}           // A value of the type of the root rule, e.g. Json{}
+	Dir           string                // Directory containing the test files ("testdata" by default)
+	ParseOptions  []grammar.ParseOption // Passed to grammar.Parse
+	PrettyOptions grammar.PrettyOptions // Used to output the parse tree
+	Seeds         []string              // Extra inputs for the seed corpus of Fuzz
+	Update        bool                  // If true, RunGolden writes golden files
+}
+
+// RunGolden parses each file with the ".input" extension in the configured
+// directory and compares the result with the contents of the file with the
+// same name and the ".golden" extension.  The result is either the pretty
+// representation of the parse tree or the error if parsing failed.  Each input
+// file is run as a subtest.
+//
+// If cfg.Update is true, golden files are written with the results instead.
+// The package does not define a flag for it, so that it cannot clash with the
+// flags of the test, but a test can set it from its own flag, e.g.
+//
+//	var update = flag.Bool("update", false, "update golden files")
+//
+//	func TestGolden(t *testing.T) {
+//	    cfg := testConfig
+//	    cfg.Update = *update
+//	    grammartest.RunGolden(t, cfg)
+//	}
+func RunGolden(t *testing.T, cfg Config) {
+	t.Helper()
+	inputs, err := inputFiles(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatalf("No input files found in %s", inputDir(cfg))
+	}
+	for _, input := range inputs {
+		input := input
+		name := strings.TrimSuffix(filepath.Base(input), ".input")
+		t.Run(name, func(t *testing.T) {
+			if err := checkGolden(cfg, input); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// checkGolden compares the result for an input file with its golden file, or
+// writes the golden file if cfg.Update is true.
+func checkGolden(cfg Config, input string) error {
+	src, err := os.ReadFile(input)
+	if err != nil {
+		return err
+	}
+	got := []byte(Render(cfg, string(src)))
+	golden := strings.TrimSuffix(input, ".input") + ".golden"
+	if cfg.Update {
+		return os.WriteFile(golden, got, 0644)
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		return fmt.Errorf("%w (set Update in the Config to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("output for %s does not match %s\nGot:\n%s\nWant:\n%s", input, golden, got, want)
+	}
+	return nil
+}
+
+// Render returns what RunGolden compares with golden files for the input src.
+func Render(cfg Config, src string) string {
+	stream, err := cfg.Tokenise(src)
+	if err != nil {
+		return fmt.Sprintf("tokenise error: %s\n", err)
+	}
+	destV := reflect.New(reflect.TypeOf(cfg.Root))
+	if err := grammar.Parse(destV.Interface(), stream, cfg.ParseOptions...); err != nil {
+		return fmt.Sprintf("parse error: %s\n", err)
+	}
+	var b strings.Builder
+	if err := grammar.PrettyWriteWithOptions(&b, destV.Elem().Interface(), cfg.PrettyOptions); err != nil {
+		return fmt.Sprintf("output error: %s\n", err)
+	}
+	if pos := stream.Save(); stream.Next().Type() != grammar.EOF.Type() {
+		fmt.Fprintf(&b, "unconsumed tokens from #%d\n", pos)
+	}
+	return b.String()
+}
+
+// inputDir returns the configured directory for input files.
+func inputDir(cfg Config) string {
+	if cfg.Dir == "" {
+		return "testdata"
+	}
+	return cfg.Dir
+}
+
+// inputFiles returns the ".input" files in the configured directory.
+func inputFiles(cfg Config) ([]string, error) {
+	return filepath.Glob(filepath.Join(inputDir(cfg), "*.input"))
+}
@@ -0,0 +1,158 @@
+package grammartest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/grammar"
+)
+
+var gtTokenise = grammar.SimpleTokeniser([]grammar.TokenDef{
+	{Ptn: `\s+`},
+	{Name: "op", Ptn: `[,]`},
+	{Name: "ident", Ptn: `[a-z]+`},
+})
+
+type gtList struct {
+	grammar.Seq
+	Items []gtItem `sep:"op,," size:"1-"`
+}
+
+type gtItem struct {
+	grammar.OneOf
+	Word  *gtWord
+	Ident *grammar.SimpleToken `tok:"ident"`
+}
+
+type gtWord struct {
+	grammar.Seq
+	Word grammar.SimpleToken `tok:"ident"`
+}
+
+// The parser panics on "boom", to check that fuzzing reports it.
+func (w *gtWord) Validate(s *grammar.ParserState) error {
+	if w.Word.Value() == "boom" {
+		panic("boom")
+	}
+	return nil
+}
+
+var gtConfig = Config{
+	Tokenise:      SimpleTokeniser(gtTokenise),
+	Root:          gtList{},
+	PrettyOptions: grammar.PrettyOptions{HideMatches: true},
+	Seeds:         []string{"a, b"},
+}
+
+// Match fields without a token value cannot be unparsed.
+type gtAny struct {
+	grammar.Seq
+	Any grammar.Match `tok:"ident"`
+}
+
+func gtToken(tp, val string) grammar.SimpleToken {
+	return grammar.SimpleToken{TokType: tp, TokValue: val}
+}
+
+func TestGolden(t *testing.T) {
+	cfg := gtConfig
+	cfg.Dir = t.TempDir()
+	inputs := map[string]string{"list": "a, b", "error": "", "tokens": "a; b"}
+	for name, src := range inputs {
+		if err := os.WriteFile(filepath.Join(cfg.Dir, name+".input"), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	input := func(name string) string {
+		return filepath.Join(cfg.Dir, name+".input")
+	}
+
+	if err := checkGolden(cfg, input("list")); err == nil || !strings.Contains(err.Error(), "set Update") {
+		t.Errorf("Got %v, want error suggesting to set Update", err)
+	}
+	cfg.Update = true
+	RunGolden(t, cfg)
+	cfg.Update = false
+	RunGolden(t, cfg)
+
+	golden, err := os.ReadFile(filepath.Join(cfg.Dir, "error.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(golden), "parse error: ") {
+		t.Errorf("Got %q, want a parse error", golden)
+	}
+	if err := os.WriteFile(filepath.Join(cfg.Dir, "list.golden"), []byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkGolden(cfg, input("list")); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("Got %v, want mismatch error", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"a", "gtList {\n  Items: [\n    gtItem {\n      Word: gtWord {\n        Word: {ident a}\n      }\n    }\n  ]\n}\n"},
+		{"a;", "tokenise error: "},
+		{"", "parse error: "},
+		{"a b", "unconsumed tokens from #1\n"},
+	}
+	for _, test := range tests {
+		if got := Render(gtConfig, test.src); !strings.Contains(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+func TestCheckInput(t *testing.T) {
+	for _, src := range []string{"a, b", "a b", "a;", ""} {
+		if err := checkInput(gtConfig, src); err != nil {
+			t.Errorf("%q: unexpected error %s", src, err)
+		}
+		CheckInput(t, gtConfig, src)
+	}
+	err := checkInput(gtConfig, "a, boom")
+	if err == nil || !strings.Contains(err.Error(), `parser panicked on input "a, boom": boom`) {
+		t.Errorf("Got %v, want panic error", err)
+	}
+}
+
+func TestCheckRoundTrip(t *testing.T) {
+	a := gtToken("ident", "a")
+	tests := []struct {
+		tree interface{}
+		want string
+	}{
+		{&gtList{Items: []gtItem{{Word: &gtWord{Word: a}}}}, ""},
+		// The OneOf rule always picks Word.
+		{&gtList{Items: []gtItem{{Ident: &a}}}, "parsing unparsed tokens gives a different tree"},
+		{&gtList{Items: []gtItem{{Word: &gtWord{Word: gtToken("op", ",")}}}}, "cannot parse unparsed tokens [{op ,}]"},
+		{&gtList{Items: []gtItem{{Word: &gtWord{Word: a}}, {Word: &gtWord{Word: a}}}}, ""},
+		{&gtAny{}, "cannot unparse tree: gtAny.Any: cannot recreate token"},
+	}
+	for i, test := range tests {
+		err := checkRoundTrip(gtConfig, test.tree)
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("%d: unexpected error %s", i, err)
+		case test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)):
+			t.Errorf("%d: got %v, want %q", i, err, test.want)
+		}
+	}
+}
+
+func TestRunEngines(t *testing.T) {
+	cfg := gtConfig
+	cfg.Dir = t.TempDir()
+	RunEngines(t, cfg)
+	for _, src := range []string{"a, b, c", "a, , b", "a b"} {
+		if err := compareEngines(cfg, src); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package grammar
+
+import (
+	"context"
+	"errors"
+)
+
+// Errors returned (wrapped in a *ParseError) when parsing is aborted because
+// a limit is exceeded.  Use errors.Is to test for them.  When the context
+// passed with WithContext is done, the error wraps the error of the context
+// instead.
+var (
+	ErrMaxDepth  = errors.New("maximum rule depth exceeded")
+	ErrMaxTokens = errors.New("maximum number of tokens exceeded")
+	ErrMaxSteps  = errors.New("maximum number of steps exceeded")
+)
+
+// parseLimits are the limits set by the options below.  A zero value means
+// no limit.
+type parseLimits struct {
+	ctx       context.Context
+	maxDepth  int
+	maxTokens int
+	maxSteps  int
+	steps     int
+}
+
+func (s *ParserState) setLimits(f func(l *parseLimits)) {
+	if s.limits == nil {
+		s.limits = &parseLimits{}
+	}
+	f(s.limits)
+}
+
+// WithContext makes parsing abort when ctx is done.
+func WithContext(ctx context.Context) ParseOption {
+	return func(s *ParserState) {
+		s.setLimits(func(l *parseLimits) { l.ctx = ctx })
+	}
+}
+
+// WithMaxDepth makes parsing abort with ErrMaxDepth if rules are nested more
+// than n deep.  This prevents deeply nested inputs from exhausting the stack.
+func WithMaxDepth(n int) ParseOption {
+	return func(s *ParserState) {
+		s.setLimits(func(l *parseLimits) { l.maxDepth = n })
+	}
+}
+
+// WithMaxTokens makes parsing abort with ErrMaxTokens if the parser reads
+// beyond the first n tokens of the token stream.
+func WithMaxTokens(n int) ParseOption {
+	return func(s *ParserState) {
+		s.setLimits(func(l *parseLimits) { l.maxTokens = n })
+	}
+}
+
+// WithMaxSteps makes parsing abort with ErrMaxSteps if more than n rules are
+// tried (including tokens).  As each rule is tried again after backtracking,
+// this bounds the time spent on inputs that cause a lot of backtracking.
+func WithMaxSteps(n int) ParseOption {
+	return func(s *ParserState) {
+		s.setLimits(func(l *parseLimits) { l.maxSteps = n })
+	}
+}
+
+// checkLimits is called before a rule is tried.  It returns a non-nil error
+// if parsing should be aborted.
+func (s *ParserState) checkLimits() *ParseError {
+	if s.aborted != nil {
+		return s.aborted
+	}
+	l := s.limits
+	if l == nil {
+		return nil
+	}
+	l.steps++
+	switch {
+	case l.maxDepth > 0 && s.depth >= l.maxDepth:
+		return s.abort(ErrMaxDepth)
+	case l.maxSteps > 0 && l.steps > l.maxSteps:
+		return s.abort(ErrMaxSteps)
+	case l.ctx != nil:
+		select {
+		case <-l.ctx.Done():
+			return s.abort(l.ctx.Err())
+		default:
+		}
+	}
+	return nil
+}
+
+// Next returns the next token in the token stream.  If the maximum number of
+// tokens is exceeded, parsing is aborted and EOF is returned.
+func (s *ParserState) Next() Token {
+	if s.limits != nil && s.limits.maxTokens > 0 && s.TokenStream.Save() >= s.limits.maxTokens {
+		s.abort(ErrMaxTokens)
+		return EOF
+	}
+	return s.TokenStream.Next()
+}
+
+// abort records that parsing must stop with the given error.  All rules tried
+// after that fail immediately with the same error, which Parse returns.
+func (s *ParserState) abort(err error) *ParseError {
+	if s.aborted == nil {
+		pos := s.TokenStream.Save()
+		tok := s.TokenStream.Next()
+		s.TokenStream.Restore(pos)
+		s.aborted = &ParseError{Err: err, Token: tok, Pos: pos}
+	}
+	return s.aborted
+}
@@ -0,0 +1,58 @@
+package grammar
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	deep := strings.Repeat("(", 10000) + strings.Repeat(")", 10000)
+	tests := []struct {
+		name    string
+		src     string
+		opt     ParseOption
+		wantErr error
+	}{
+		{"depth ok", "((1))", WithMaxDepth(10), nil},
+		{"depth exceeded", deep, WithMaxDepth(100), ErrMaxDepth},
+		{"tokens ok", "(1 2)", WithMaxTokens(4), nil},
+		{"tokens exceeded", "(1 2 3 4 5)", WithMaxTokens(4), ErrMaxTokens},
+		{"steps ok", "(1 2)", WithMaxSteps(100), nil},
+		{"steps exceeded", "(1 2 3 4 5)", WithMaxSteps(20), ErrMaxSteps},
+		{"context ok", "(1 2)", WithContext(context.Background()), nil},
+		{"context cancelled", "(1 2)", WithContext(cancelled), context.Canceled},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stream, err := testTokenise(test.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var expr testExpr
+			parseErr := Parse(&expr, stream, test.opt)
+			switch {
+			case test.wantErr == nil && parseErr != nil:
+				t.Errorf("Unexpected error: %s", parseErr)
+			case test.wantErr != nil && !errors.Is(parseErr, test.wantErr):
+				t.Errorf("Got error %v, want %v", parseErr, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestLimitsErrorMessage(t *testing.T) {
+	stream, err := testTokenise("(1 2 3 4 5)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expr testExpr
+	parseErr := Parse(&expr, stream, WithMaxTokens(3))
+	want := `token #3 number with value "3": maximum number of tokens exceeded`
+	if parseErr == nil || parseErr.Error() != want {
+		t.Errorf("Got error %v, want %s", parseErr, want)
+	}
+}
@@ -0,0 +1,570 @@
+package grammar
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// AnalyseLL computes the FIRST and FOLLOW sets of the rule type of r and all
+// the rules it refers to, with k tokens of lookahead, and reports the places
+// where the parser cannot decide what to do by looking at the next k tokens:
+//
+//   - alternatives of a OneOf rule which can start with the same tokens;
+//   - optional fields whose tokens can also follow them;
+//   - repeated fields where the tokens which continue the repetition can also
+//     follow it.
+//
+// The parser backtracks in those places (or chooses the first alternative
+// when several match), so a grammar without conflicts is predictable and
+// cheap to parse.  The analysis works on the structure of the rules and their
+// tok, sep and size tags.  Lookahead fields, tokens which are not consumed
+// (with the "*" suffix) and drop tags are ignored, and the Parse methods of
+// tokens are assumed to match the options given by their tok tag.
+func AnalyseLL(r interface{}, k int) (*LLReport, error) {
+	if k < 1 {
+		return nil, fmt.Errorf("invalid lookahead %d", k)
+	}
+	tp := reflect.TypeOf(r)
+	if tp != nil && tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	ruleDefs, err := reachableRuleDefs(tp)
+	if err != nil {
+		return nil, err
+	}
+	a := &llAnalysis{
+		k:         k,
+		ruleDefs:  ruleDefs,
+		first:     map[reflect.Type]seqSet{},
+		follow:    map[reflect.Type]seqSet{},
+		termIndex: map[string]int{},
+	}
+	a.computeFirst()
+	a.computeFollow(tp)
+	report := &LLReport{K: k}
+	for _, ruleDef := range ruleDefs {
+		report.Rules = append(report.Rules, LLRuleSets{
+			Name:   ruleDef.Name,
+			First:  a.sequences(a.first[ruleDef.Type]),
+			Follow: a.sequences(a.follow[ruleDef.Type]),
+		})
+		report.Conflicts = append(report.Conflicts, a.conflicts(ruleDef)...)
+	}
+	return report, nil
+}
+
+// An LLReport is the result of AnalyseLL.
+type LLReport struct {
+	K         int // Number of tokens of lookahead
+	Rules     []LLRuleSets
+	Conflicts []LLConflict
+}
+
+// LLRuleSets are the FIRST and FOLLOW sets of a rule.  Each set contains
+// sequences of at most K token descriptions (e.g. `op "+"` or `ident`).  A
+// sequence shorter than K means that the input can end after it.
+type LLRuleSets struct {
+	Name   string
+	First  [][]string // Sequences of tokens the rule can start with
+	Follow [][]string // Sequences of tokens which can follow the rule
+}
+
+// An LLConflict is a place in a rule where the next K tokens are not enough to
+// decide how to continue parsing.
+type LLConflict struct {
+	Rule    string
+	Kind    LLConflictKind
+	Fields  []string // The two alternatives, or the optional or repeated field
+	Example []string // Tokens which could be parsed both ways
+}
+
+// LLConflictKind is the kind of an LLConflict.
+type LLConflictKind uint8
+
+const (
+	LLAlternatives LLConflictKind = iota // Two alternatives of a OneOf rule
+	LLOptional                           // Matching an optional field or skipping it
+	LLRepeat                             // Continuing a repeated field or stopping
+)
+
+func (k LLConflictKind) String() string {
+	switch k {
+	case LLAlternatives:
+		return "alternatives"
+	case LLOptional:
+		return "optional"
+	case LLRepeat:
+		return "repeat"
+	default:
+		return "unknown"
+	}
+}
+
+func (c LLConflict) String() string {
+	example := strings.Join(c.Example, " ")
+	if example == "" {
+		example = "end of input"
+	}
+	switch c.Kind {
+	case LLAlternatives:
+		return fmt.Sprintf("%s: alternatives %s and %s can both start with %s", c.Rule, c.Fields[0], c.Fields[1], example)
+	case LLOptional:
+		return fmt.Sprintf("%s.%s: optional field can be matched or skipped on %s", c.Rule, c.Fields[0], example)
+	default:
+		return fmt.Sprintf("%s.%s: repeated field can continue or stop on %s", c.Rule, c.Fields[0], example)
+	}
+}
+
+// WriteReport writes the conflicts, one per line.
+func (r *LLReport) WriteReport(out io.Writer) error {
+	for _, c := range r.Conflicts {
+		if _, err := fmt.Fprintln(out, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A seqSet is a set of token sequences, each encoded as a string where each
+// rune is the index of a terminal plus one.
+type seqSet map[string]struct{}
+
+var emptySeqSet = seqSet{"": {}}
+
+func (s seqSet) add(t seqSet) bool {
+	changed := false
+	for seq := range t {
+		if _, ok := s[seq]; !ok {
+			s[seq] = struct{}{}
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (s seqSet) sorted() []string {
+	seqs := make([]string, 0, len(s))
+	for seq := range s {
+		seqs = append(seqs, seq)
+	}
+	sort.Strings(seqs)
+	return seqs
+}
+
+type llAnalysis struct {
+	k         int
+	ruleDefs  []reachableRuleDef
+	first     map[reflect.Type]seqSet
+	follow    map[reflect.Type]seqSet
+	terms     []TokenParseOptions
+	termIndex map[string]int
+}
+
+// concat returns the sequences of s followed by those of t, truncated to k
+// tokens.
+func (a *llAnalysis) concat(s, t seqSet) seqSet {
+	res := seqSet{}
+	for x := range s {
+		xr := []rune(x)
+		if len(xr) >= a.k {
+			res[x] = struct{}{}
+			continue
+		}
+		for y := range t {
+			xy := append(xr[:len(xr):len(xr)], []rune(y)...)
+			if len(xy) > a.k {
+				xy = xy[:a.k]
+			}
+			res[string(xy)] = struct{}{}
+		}
+	}
+	return res
+}
+
+func (a *llAnalysis) union(sets ...seqSet) seqSet {
+	res := seqSet{}
+	for _, s := range sets {
+		res.add(s)
+	}
+	return res
+}
+
+// term returns the terminal for a token option.
+func (a *llAnalysis) term(opt TokenParseOptions) rune {
+	opt.Class = ""
+	key := tokenOptionsLabel(TokenOptions{TokenParseOptions: []TokenParseOptions{opt}})
+	i, ok := a.termIndex[key]
+	if !ok {
+		i = len(a.terms)
+		a.terms = append(a.terms, opt)
+		a.termIndex[key] = i
+	}
+	return rune(i + 1)
+}
+
+// tokenFirst returns the sequences matched by token options.
+func (a *llAnalysis) tokenFirst(opts TokenOptions) seqSet {
+	if len(opts.TokenParseOptions) == 0 {
+		return emptySeqSet
+	}
+	res := seqSet{}
+	for _, opt := range opts.TokenParseOptions {
+		if opt.DoNotConsume {
+			res[""] = struct{}{}
+		} else {
+			res[string(a.term(opt))] = struct{}{}
+		}
+	}
+	return res
+}
+
+// symbolFirst returns the FIRST set of a rule or token.
+func (a *llAnalysis) symbolFirst(tp reflect.Type, opts TokenOptions) seqSet {
+	if _, err := getRuleDef(tp); err == nil {
+		return a.first[tp]
+	}
+	return a.tokenFirst(opts)
+}
+
+// sepFirst returns the FIRST set of the separator of a repeated field, which
+// is empty if there is none.
+func (a *llAnalysis) sepFirst(ruleField RuleField) seqSet {
+	if ruleField.SepRule != nil {
+		return a.first[ruleField.SepRule]
+	}
+	if len(ruleField.SepOptions.TokenParseOptions) > 0 {
+		return a.tokenFirst(ruleField.SepOptions)
+	}
+	return emptySeqSet
+}
+
+// itemsFirst returns the FIRST set of the items of a repeated field, with
+// their separators.  If atLeastOne is true, empty repetitions are excluded.
+func (a *llAnalysis) itemsFirst(ruleField RuleField, atLeastOne bool) seqSet {
+	item := a.symbolFirst(ruleField.BaseType, ruleField.TokenOptions)
+	sep := a.sepFirst(ruleField)
+	min := int(ruleField.Min)
+	if atLeastOne && min == 0 {
+		min = 1
+	}
+	// More items than this all have the same first k tokens.
+	max := min + a.k
+	if ruleField.Max > 0 && int(ruleField.Max) < max {
+		max = int(ruleField.Max)
+	}
+	res := seqSet{}
+	if min == 0 {
+		res[""] = struct{}{}
+	}
+	items := item
+	for n := 1; n <= max; n++ {
+		if n >= min {
+			res.add(a.withSeps(items, sep, ruleField))
+		}
+		items = a.concat(items, a.concat(sep, item))
+	}
+	return res
+}
+
+// withSeps adds the leading and trailing separators allowed by the field to
+// the sequences of items.
+func (a *llAnalysis) withSeps(items, sep seqSet, ruleField RuleField) seqSet {
+	switch ruleField.Leading {
+	case SepAllow:
+		items = a.union(items, a.concat(sep, items))
+	case SepRequire:
+		items = a.concat(sep, items)
+	}
+	return a.concat(items, a.trailing(sep, ruleField, emptySeqSet))
+}
+
+// trailing returns the sequences which can follow the last item of a repeated
+// field, given those which follow the field.
+func (a *llAnalysis) trailing(sep seqSet, ruleField RuleField, follow seqSet) seqSet {
+	switch ruleField.Trailing {
+	case SepAllow:
+		return a.union(follow, a.concat(sep, follow))
+	case SepRequire:
+		return a.concat(sep, follow)
+	default:
+		return follow
+	}
+}
+
+// fieldFirst returns the FIRST set of a field in a Seq rule.
+func (a *llAnalysis) fieldFirst(ruleField RuleField) seqSet {
+	switch {
+	case ruleField.Lookahead != NoLookahead:
+		return emptySeqSet
+	case ruleField.Pointer:
+		return a.union(a.symbolFirst(ruleField.BaseType, ruleField.TokenOptions), emptySeqSet)
+	case ruleField.Array:
+		return a.itemsFirst(ruleField, false)
+	default:
+		return a.symbolFirst(ruleField.BaseType, ruleField.TokenOptions)
+	}
+}
+
+// altFirst returns the FIRST set of an alternative of a OneOf rule.
+func (a *llAnalysis) altFirst(ruleField RuleField) seqSet {
+	if ruleField.Array {
+		return a.itemsFirst(ruleField, true)
+	}
+	return a.symbolFirst(ruleField.BaseType, ruleField.TokenOptions)
+}
+
+// restFirst returns the FIRST set of the fields of a Seq rule from position i,
+// followed by the FOLLOW set of the rule.
+func (a *llAnalysis) restFirst(ruleDef *reachableRuleDef, i int) seqSet {
+	res := a.follow[ruleDef.Type]
+	for j := len(ruleDef.Fields) - 1; j >= i; j-- {
+		res = a.concat(a.fieldFirst(ruleDef.Fields[j]), res)
+	}
+	return res
+}
+
+func (a *llAnalysis) computeFirst() {
+	for _, ruleDef := range a.ruleDefs {
+		a.first[ruleDef.Type] = seqSet{}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, ruleDef := range a.ruleDefs {
+			var first seqSet
+			if ruleDef.OneOf {
+				first = seqSet{}
+				for _, ruleField := range ruleDef.Fields {
+					first.add(a.altFirst(ruleField))
+				}
+			} else {
+				first = emptySeqSet
+				for i := len(ruleDef.Fields) - 1; i >= 0; i-- {
+					first = a.concat(a.fieldFirst(ruleDef.Fields[i]), first)
+				}
+			}
+			if a.first[ruleDef.Type].add(first) {
+				changed = true
+			}
+		}
+	}
+}
+
+// computeFollow computes the FOLLOW sets, the root rule being followed by the
+// end of input.
+func (a *llAnalysis) computeFollow(root reflect.Type) {
+	for _, ruleDef := range a.ruleDefs {
+		a.follow[ruleDef.Type] = seqSet{}
+	}
+	a.follow[root].add(emptySeqSet)
+	for changed := true; changed; {
+		changed = false
+		addFollow := func(tp reflect.Type, follow seqSet) {
+			if set, ok := a.follow[tp]; ok && set.add(follow) {
+				changed = true
+			}
+		}
+		for i := range a.ruleDefs {
+			ruleDef := &a.ruleDefs[i]
+			for j, ruleField := range ruleDef.Fields {
+				if ruleField.Lookahead != NoLookahead {
+					continue
+				}
+				var rest seqSet
+				if ruleDef.OneOf {
+					rest = a.follow[ruleDef.Type]
+				} else {
+					rest = a.restFirst(ruleDef, j+1)
+				}
+				if !ruleField.Array {
+					addFollow(ruleField.BaseType, rest)
+					continue
+				}
+				afterItem, afterSep := a.itemFollow(ruleField, rest)
+				addFollow(ruleField.BaseType, afterItem)
+				if ruleField.SepRule != nil {
+					addFollow(ruleField.SepRule, afterSep)
+				}
+			}
+		}
+	}
+}
+
+// itemFollow returns the sequences which can follow an item of a repeated
+// field and those which can follow a separator, given those which follow the
+// field.
+func (a *llAnalysis) itemFollow(ruleField RuleField, rest seqSet) (afterItem, afterSep seqSet) {
+	item := a.symbolFirst(ruleField.BaseType, ruleField.TokenOptions)
+	sep := a.sepFirst(ruleField)
+	// After an item come more items or the end of the repetition.
+	more := a.concat(a.concat(sep, a.itemsFirstNoLeading(ruleField)), rest)
+	afterItem = a.union(more, a.trailing(sep, ruleField, rest))
+	afterSep = a.concat(item, afterItem)
+	if ruleField.Trailing != SepForbid {
+		afterSep = a.union(afterSep, rest)
+	}
+	return afterItem, afterSep
+}
+
+// itemsFirstNoLeading is like itemsFirst with at least one item, but without
+// a leading separator.
+func (a *llAnalysis) itemsFirstNoLeading(ruleField RuleField) seqSet {
+	ruleField.Leading = SepForbid
+	return a.itemsFirst(ruleField, true)
+}
+
+// conflicts returns the conflicts in a rule.
+func (a *llAnalysis) conflicts(ruleDef reachableRuleDef) []LLConflict {
+	var conflicts []LLConflict
+	add := func(kind LLConflictKind, x, y seqSet, fields ...string) {
+		if example, ok := a.overlap(x, y); ok {
+			conflicts = append(conflicts, LLConflict{
+				Rule:    ruleDef.Name,
+				Kind:    kind,
+				Fields:  fields,
+				Example: example,
+			})
+		}
+	}
+	if ruleDef.OneOf {
+		follow := a.follow[ruleDef.Type]
+		alts := ruleDef.Fields
+		firsts := make([]seqSet, len(alts))
+		for i, ruleField := range alts {
+			firsts[i] = a.concat(a.altFirst(ruleField), follow)
+		}
+		for i := range alts {
+			for j := i + 1; j < len(alts); j++ {
+				add(LLAlternatives, firsts[i], firsts[j], alts[i].Name, alts[j].Name)
+			}
+		}
+	}
+	for i, ruleField := range ruleDef.Fields {
+		if ruleField.Lookahead != NoLookahead {
+			continue
+		}
+		var rest seqSet
+		if ruleDef.OneOf {
+			if !ruleField.Array {
+				continue
+			}
+			rest = a.follow[ruleDef.Type]
+		} else {
+			rest = a.restFirst(&ruleDef, i+1)
+		}
+		switch {
+		case ruleField.Pointer:
+			take := a.concat(a.symbolFirst(ruleField.BaseType, ruleField.TokenOptions), rest)
+			add(LLOptional, take, rest, ruleField.Name)
+		case ruleField.Array:
+			more, stop := a.repeatChoices(ruleDef.RuleDef, ruleField, rest)
+			add(LLRepeat, more, stop, ruleField.Name)
+		}
+	}
+	return conflicts
+}
+
+// repeatChoices returns the sequences which continue a repeated field and
+// those which stop it, given those which follow the field.  The choice is made
+// after an item, or after a separator when there can be one after the last
+// item.  It is also made before the first item when it is optional.
+func (a *llAnalysis) repeatChoices(ruleDef *RuleDef, ruleField RuleField, rest seqSet) (more, stop seqSet) {
+	items := a.concat(a.itemsFirstNoLeading(ruleField), rest)
+	more, stop = seqSet{}, seqSet{}
+	if ruleField.Max != 1 {
+		sep := a.sepFirst(ruleField)
+		switch {
+		case !ruleField.hasSep():
+			more.add(items)
+			stop.add(rest)
+		case ruleField.Trailing == SepForbid:
+			more.add(a.concat(sep, items))
+			stop.add(rest)
+		case ruleField.Trailing == SepAllow:
+			more.add(a.concat(sep, a.union(items, rest)))
+			stop.add(rest)
+			fallthrough
+		default:
+			// After a separator
+			more.add(items)
+			stop.add(rest)
+		}
+	}
+	if ruleField.Min == 0 && !ruleDef.OneOf {
+		more.add(a.concat(a.itemsFirst(ruleField, true), rest))
+		stop.add(rest)
+	}
+	return more, stop
+}
+
+// overlap returns an example of a sequence matched by both x and y, if there
+// is one.  Sequences overlap if they have the same length and their tokens
+// overlap (e.g. ident and ident "x").
+func (a *llAnalysis) overlap(x, y seqSet) ([]string, bool) {
+	ys := y.sorted()
+	for _, sx := range x.sorted() {
+		rx := []rune(sx)
+	next:
+		for _, sy := range ys {
+			ry := []rune(sy)
+			if len(rx) != len(ry) {
+				continue
+			}
+			example := make([]string, len(rx))
+			for i := range rx {
+				tx, ty := a.terms[rx[i]-1], a.terms[ry[i]-1]
+				if !termsOverlap(tx, ty) {
+					continue next
+				}
+				if tx.valueLabel() == "" {
+					tx = ty
+				}
+				example[i] = a.termLabel(tx)
+			}
+			return example, true
+		}
+	}
+	return nil, false
+}
+
+// termsOverlap returns true if a token can match both options.  When it cannot
+// be decided (e.g. for two regular expressions), it returns true.
+func termsOverlap(x, y TokenParseOptions) bool {
+	if x.TokenType != "" && y.TokenType != "" && x.TokenType != y.TokenType {
+		return false
+	}
+	if x.valueLabel() == "" || y.valueLabel() == "" {
+		return true
+	}
+	switch {
+	case x.valueRegexp != nil && y.valueRegexp != nil:
+		return true
+	case x.valueRegexp != nil:
+		return x.valueRegexp.MatchString(y.TokenValue)
+	case y.valueRegexp != nil:
+		return y.valueRegexp.MatchString(x.TokenValue)
+	case x.IgnoreCase || y.IgnoreCase:
+		return strings.EqualFold(x.TokenValue, y.TokenValue)
+	default:
+		return x.TokenValue == y.TokenValue
+	}
+}
+
+func (a *llAnalysis) termLabel(opt TokenParseOptions) string {
+	return tokenOptionsLabel(TokenOptions{TokenParseOptions: []TokenParseOptions{opt}})
+}
+
+// sequences returns the sequences of a set as token descriptions.
+func (a *llAnalysis) sequences(s seqSet) [][]string {
+	var res [][]string
+	for _, seq := range s.sorted() {
+		labels := []string{}
+		for _, r := range seq {
+			labels = append(labels, a.termLabel(a.terms[r-1]))
+		}
+		res = append(res, labels)
+	}
+	return res
+}
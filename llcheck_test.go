@@ -0,0 +1,132 @@
+package grammar
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type llProgram struct {
+	Seq
+	Stmts []llStmt `term:"op,;"`
+	End   Match    `tok:"EOF"`
+}
+
+type llStmt struct {
+	OneOf
+	Call   *llCall
+	Assign *llAssign
+	Decl   *llDecl
+}
+
+type llCall struct {
+	Seq
+	Name  SimpleToken `tok:"ident"`
+	Open  Match       `tok:"op,("`
+	Args  []llExpr    `sep:"op,," trailing:"allow"`
+	Close Match       `tok:"op,)"`
+}
+
+type llAssign struct {
+	Seq
+	Name  SimpleToken `tok:"ident"`
+	Eq    Match       `tok:"op,="`
+	Value llExpr
+}
+
+type llDecl struct {
+	Seq
+	Kw   Match        `tok:"ident,'var'i"`
+	Type *SimpleToken `tok:"ident"`
+	Name SimpleToken  `tok:"ident"`
+}
+
+type llExpr struct {
+	OneOf
+	Number *SimpleToken `tok:"number"`
+	Paren  *llParen
+}
+
+type llParen struct {
+	Seq
+	Open  Match `tok:"op,("`
+	Expr  llExpr
+	Close Match `tok:"op,)"`
+}
+
+// The repetition is greedy, so Last never matches.  Two tokens of lookahead
+// would be enough to know when to stop.
+type llGreedy struct {
+	Seq
+	Items []SimpleToken `tok:"ident"`
+	Last  SimpleToken   `tok:"ident"`
+}
+
+func TestAnalyseLL(t *testing.T) {
+	tests := []struct {
+		rule interface{}
+		k    int
+		want string
+	}{
+		{llProgram{}, 1, `llStmt: alternatives Call and Assign can both start with ident
+llStmt: alternatives Call and Decl can both start with ident "var"i
+llStmt: alternatives Assign and Decl can both start with ident "var"i
+llDecl.Type: optional field can be matched or skipped on ident
+`},
+		{llProgram{}, 2, ""},
+		{&llGreedy{}, 1, "llGreedy.Items: repeated field can continue or stop on ident\n"},
+		{llGreedy{}, 2, ""},
+	}
+	for _, test := range tests {
+		report, err := AnalyseLL(test.rule, test.k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b strings.Builder
+		if err := report.WriteReport(&b); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); got != test.want {
+			t.Errorf("%T with k=%d: got:\n%s\nwant:\n%s", test.rule, test.k, got, test.want)
+		}
+	}
+}
+
+func TestAnalyseLLSets(t *testing.T) {
+	report, err := AnalyseLL(llProgram{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets := map[string]LLRuleSets{}
+	for _, rs := range report.Rules {
+		sets[rs.Name] = rs
+	}
+	wantFirst := [][]string{{`op "("`, `op "("`}, {`op "("`, "number"}, {"number"}}
+	if got := sets["llExpr"].First; !reflect.DeepEqual(got, wantFirst) {
+		t.Errorf("FIRST(llExpr): got %q, want %q", got, wantFirst)
+	}
+	wantFollow := [][]string{{`op ";"`}, {`op ")"`}, {`op ","`}}
+	var follow [][]string
+	for _, seq := range sets["llExpr"].Follow {
+		follow = append(follow, seq[:1])
+	}
+	if got := dedupSeqs(follow); !reflect.DeepEqual(got, wantFollow) {
+		t.Errorf("FOLLOW(llExpr): got %q, want %q", got, wantFollow)
+	}
+	if _, err := AnalyseLL(llProgram{}, 0); err == nil {
+		t.Errorf("Expected an error for k=0")
+	}
+}
+
+func dedupSeqs(seqs [][]string) [][]string {
+	var res [][]string
+	seen := map[string]bool{}
+	for _, seq := range seqs {
+		key := strings.Join(seq, " ")
+		if !seen[key] {
+			seen[key] = true
+			res = append(res, seq)
+		}
+	}
+	return res
+}
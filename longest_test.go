@@ -0,0 +1,130 @@
+package grammar
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type lgA struct {
+	Seq
+	A SimpleToken `tok:"ident,a"`
+}
+
+type lgAB struct {
+	Seq
+	A SimpleToken `tok:"ident,a"`
+	B SimpleToken `tok:"ident,b"`
+}
+
+type lgIdent struct {
+	Seq
+	Name SimpleToken `tok:"ident"`
+}
+
+// With OneOf, AB would never match.
+type lgStmt struct {
+	Longest
+	A     *lgA
+	AB    *lgAB
+	Ident *lgIdent
+	Words []SimpleToken `tok:"ident"`
+}
+
+type lgStrict struct {
+	Longest `ties:"error"`
+	A       *lgA
+	AB      *lgAB
+	Ident   *lgIdent
+}
+
+type lgProgram struct {
+	Seq
+	Stmts []lgStmt `term:"op,;"`
+	End   Match    `tok:"EOF"`
+}
+
+type lgStrictProgram struct {
+	Seq
+	Stmts []lgStrict `term:"op,;"`
+	End   Match      `tok:"EOF"`
+}
+
+func lgAlternative(r interface{}) string {
+	v := reflect.ValueOf(r)
+	tp := v.Type()
+	for i := 1; i < v.NumField(); i++ {
+		if f := v.Field(i); !f.IsZero() {
+			return tp.Field(i).Name
+		}
+	}
+	return "none"
+}
+
+func TestLongest(t *testing.T) {
+	tests := []struct {
+		dest interface{}
+		src  string
+		want string
+	}{
+		{&lgProgram{}, "a; a b; x; a b c;", "[A AB Ident Words]"},
+		{&lgStrictProgram{}, "a b; x;", "[AB Ident]"},
+	}
+	for _, test := range tests {
+		name := fmt.Sprintf("%T %s", test.dest, test.src)
+		t.Run(name, func(t *testing.T) {
+			res := parseBothEngines(t, test.dest, test.src)
+			var got string
+			if res.Err != nil {
+				got = res.Err.Error()
+			} else {
+				stmts := reflect.ValueOf(res.Tree).Elem().Field(1)
+				var alts []string
+				for i := 0; i < stmts.Len(); i++ {
+					alts = append(alts, lgAlternative(stmts.Index(i).Interface()))
+				}
+				got = fmt.Sprint(alts)
+			}
+			if got != test.want {
+				t.Errorf("Got:\n%s\nWant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLongestTieError(t *testing.T) {
+	stream, err := testLangTokenise("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dest lgStrict
+	parseErr := Parse(&dest, stream)
+	if !errors.Is(parseErr, ErrAmbiguous) {
+		t.Fatalf("Got %v, want ErrAmbiguous", parseErr)
+	}
+	want := `token #0 ident with value "a": ambiguous match: A and Ident both match 1 tokens`
+	if got := parseErr.Error(); got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+}
+
+func TestLongestInvalid(t *testing.T) {
+	type badTies struct {
+		Longest `ties:"maybe"`
+		A       *lgA
+	}
+	type tiesInOneOf struct {
+		OneOf `ties:"error"`
+		A     *lgA
+	}
+	type notPointer struct {
+		Longest
+		A lgA
+	}
+	for _, r := range []interface{}{badTies{}, tiesInOneOf{}, notPointer{}} {
+		if _, err := calcRuleDef(reflect.TypeOf(r)); err == nil {
+			t.Errorf("Expected error for %T", r)
+		}
+	}
+}
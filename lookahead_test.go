@@ -0,0 +1,93 @@
+package grammar
+
+import (
+	"reflect"
+	"testing"
+)
+
+type laKeyword struct {
+	OneOf
+	Let *SimpleToken `tok:"ident,let"`
+	In  *SimpleToken `tok:"ident,in"`
+}
+
+// An identifier which is not a keyword.
+type laName struct {
+	Seq
+	NotKw laKeyword   `lookahead:"not"`
+	Name  SimpleToken `tok:"ident"`
+}
+
+type laStmt struct {
+	OneOf
+	Assign *laAssign
+	Expr   *laExpr
+}
+
+type laAssign struct {
+	Seq
+	Name  laName
+	Eq    Match       `tok:"op,="`
+	Value SimpleToken `tok:"number"`
+}
+
+// An identifier not followed by "=" and followed by ";", which is not
+// consumed.
+type laExpr struct {
+	Seq
+	Name  laName
+	NotEq Match `tok:"op,=" lookahead:"not"`
+	Semi  Match `tok:"op,;" lookahead:"and"`
+}
+
+func TestLookahead(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{
+			src:  "x = 1",
+			want: "laStmt {Assign: laAssign {Name: laName {Name: {ident x}}, Eq: {}, Value: {number 1}}} @3",
+		},
+		{
+			src:  "x ;",
+			want: "laStmt {Expr: laExpr {Name: laName {Name: {ident x}}}} @1",
+		},
+		{
+			src:  "let = 1",
+			want: `error: token #0 ident with value "let": unexpected laKeyword`,
+		},
+		{
+			src:  "x = ;",
+			want: `error: token #2 op with value ";": expected token with type number`,
+		},
+		{
+			src:  "x",
+			want: `error: token #1 EOF with value "EOF": expected token with value "=" or ";"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.src, func(t *testing.T) {
+			got := parseBothEngines(t, &laStmt{}, test.src).String()
+			if got != test.want {
+				t.Errorf("Got:\n%s\nWant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLookaheadInvalid(t *testing.T) {
+	type optional struct {
+		Seq
+		X *SimpleToken `tok:"ident" lookahead:"not"`
+	}
+	type badTag struct {
+		Seq
+		X SimpleToken `tok:"ident" lookahead:"maybe"`
+	}
+	for _, r := range []interface{}{optional{}, badTag{}} {
+		if _, err := calcRuleDef(reflect.TypeOf(r)); err == nil {
+			t.Errorf("Expected error for %T", r)
+		}
+	}
+}
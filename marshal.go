@@ -0,0 +1,511 @@
+package grammar
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A TreeFormat is a serialisation format for parse trees.
+type TreeFormat int
+
+const (
+	// JSONTree is a JSON representation of parse trees.  A rule is an object
+	// with a "rule" name and a list of "fields", each with a "name" and either
+	// a "node" or a list of "items".  A token is an object with a "token"
+	// containing its "type" and "value".
+	JSONTree TreeFormat = iota
+
+	// SExprTree is an S-expression representation of parse trees, in the
+	// style of tree-sitter.  A rule is written (Name Field1: ... Field2: ...),
+	// with repeated fields written [item1 item2 ...], and a token is written
+	// (type "value").
+	SExprTree
+)
+
+// MarshalTree serialises the parse tree r in the given format.  Optional and
+// repeated fields which were not matched are omitted, as well as fields with
+// no content (such as Match fields).
+func MarshalTree(r interface{}, format TreeFormat) ([]byte, error) {
+	v := reflect.ValueOf(r)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	node, err := marshalNode(v)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, fmt.Errorf("cannot marshal %s as a tree", v.Type())
+	}
+	switch format {
+	case JSONTree:
+		return json.MarshalIndent(node, "", "  ")
+	case SExprTree:
+		var b bytes.Buffer
+		writeSExprNode(&b, node, 0)
+		b.WriteByte('\n')
+		return b.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unknown tree format %d", format)
+	}
+}
+
+// UnmarshalTree rebuilds a parse tree serialised by MarshalTree.  The type of
+// the root rule is found in rules, and it returns a pointer to a new value of
+// that type.
+func UnmarshalTree(data []byte, format TreeFormat, rules *RuleRegistry) (interface{}, error) {
+	var node *treeNode
+	switch format {
+	case JSONTree:
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+		if node == nil {
+			return nil, errors.New("null tree")
+		}
+	case SExprTree:
+		p := sexprParser{src: data}
+		var err error
+		node, err = p.parseTree()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown tree format %d", format)
+	}
+	if node.Rule == "" {
+		return nil, errors.New("root node should be a rule")
+	}
+	tp, ok := rules.Lookup(node.Rule)
+	if !ok {
+		return nil, fmt.Errorf("unknown rule %s", node.Rule)
+	}
+	ptrV := reflect.New(tp)
+	if err := unmarshalNode(node, ptrV.Elem()); err != nil {
+		return nil, err
+	}
+	return ptrV.Interface(), nil
+}
+
+// A RuleRegistry maps rule names to rule types.
+type RuleRegistry struct {
+	types map[string]reflect.Type
+}
+
+// NewRuleRegistry returns a registry containing the rules given as arguments
+// and all the rules they refer to.
+func NewRuleRegistry(rules ...interface{}) (*RuleRegistry, error) {
+	reg := &RuleRegistry{types: map[string]reflect.Type{}}
+	for _, r := range rules {
+		if err := reg.Register(r); err != nil {
+			return nil, err
+		}
+	}
+	return reg, nil
+}
+
+// Register adds the type of the rule r to the registry, as well as the types
+// of all the rules it refers to.  It is an error to register two different
+// rule types with the same name.
+func (reg *RuleRegistry) Register(r interface{}) error {
+	tp := reflect.TypeOf(r)
+	if tp != nil && tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	ruleDefs, err := reachableRuleDefs(tp)
+	if err != nil {
+		return err
+	}
+	for _, ruleDef := range ruleDefs {
+		if other, ok := reg.types[ruleDef.Name]; ok && other != ruleDef.Type {
+			return fmt.Errorf("rule name %s used by %s and %s", ruleDef.Name, other, ruleDef.Type)
+		}
+		reg.types[ruleDef.Name] = ruleDef.Type
+	}
+	return nil
+}
+
+// Lookup returns the rule type registered with the given name.
+func (reg *RuleRegistry) Lookup(name string) (reflect.Type, bool) {
+	tp, ok := reg.types[name]
+	return tp, ok
+}
+
+type reachableRuleDef struct {
+	*RuleDef
+	Type reflect.Type
+}
+
+// reachableRuleDefs returns the rule definitions of tp and all the rules it
+// refers to, directly or indirectly, in depth-first order.
+func reachableRuleDefs(tp reflect.Type) ([]reachableRuleDef, error) {
+	if tp == nil {
+		return nil, errors.New("nil rule")
+	}
+	var ruleDefs []reachableRuleDef
+	seen := map[reflect.Type]bool{}
+	var visit func(reflect.Type) error
+	visit = func(tp reflect.Type) error {
+		if seen[tp] {
+			return nil
+		}
+		seen[tp] = true
+		ruleDef, err := getRuleDef(tp)
+		if err != nil {
+			return nil
+		}
+		ruleDefs = append(ruleDefs, reachableRuleDef{RuleDef: ruleDef, Type: tp})
+		for _, ruleField := range ruleDef.Fields {
+			if err := visit(ruleField.BaseType); err != nil {
+				return err
+			}
+			if ruleField.SepRule != nil {
+				if err := visit(ruleField.SepRule); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if _, err := getRuleDef(tp); err != nil {
+		return nil, fmt.Errorf("%s is not a valid rule: %s", tp, err)
+	}
+	err := visit(tp)
+	return ruleDefs, err
+}
+
+// treeNode is the intermediate representation of a parse tree node used by
+// all tree formats.
+type treeNode struct {
+	Rule   string      `json:"rule,omitempty"`
+	Fields []treeField `json:"fields,omitempty"`
+	Token  *treeToken  `json:"token,omitempty"`
+}
+
+type treeField struct {
+	Name  string      `json:"name"`
+	Node  *treeNode   `json:"node,omitempty"`
+	Items []*treeNode `json:"items,omitempty"`
+}
+
+type treeToken struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+var tokenType = reflect.TypeOf((*Token)(nil)).Elem()
+
+// marshalNode returns the tree node representing v, or nil if v has no
+// content.
+func marshalNode(v reflect.Value) (*treeNode, error) {
+	ruleDef, err := getRuleDef(v.Type())
+	if err != nil {
+		if v.Type().Implements(tokenType) {
+			tok := v.Interface().(Token)
+			return &treeNode{Token: &treeToken{Type: tok.Type(), Value: tok.Value()}}, nil
+		}
+		if v.Type().Size() == 0 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cannot marshal value of type %s", v.Type())
+	}
+	node := &treeNode{Rule: ruleDef.Name}
+	for _, ruleField := range ruleDef.Fields {
+		fieldV := v.Field(ruleField.Index)
+		field := treeField{Name: ruleField.Name}
+		switch {
+		case ruleField.Lookahead != NoLookahead:
+			continue
+		case ruleField.Pointer:
+			if fieldV.IsNil() {
+				continue
+			}
+			field.Node, err = marshalNode(fieldV.Elem())
+		case ruleField.Array:
+			for i := 0; i < fieldV.Len() && err == nil; i++ {
+				var item *treeNode
+				item, err = marshalNode(fieldV.Index(i))
+				field.Items = append(field.Items, item)
+			}
+		default:
+			field.Node, err = marshalNode(fieldV)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if field.Node != nil || len(field.Items) > 0 && field.Items[0] != nil {
+			node.Fields = append(node.Fields, field)
+		}
+	}
+	return node, nil
+}
+
+// unmarshalNode loads the value v with the contents of the tree node.
+func unmarshalNode(node *treeNode, v reflect.Value) error {
+	if node == nil {
+		return fmt.Errorf("missing value of type %s", v.Type())
+	}
+	if node.Token != nil {
+		return unmarshalToken(node.Token, v)
+	}
+	ruleDef, err := getRuleDef(v.Type())
+	if err != nil {
+		return fmt.Errorf("rule %s found for value of type %s", node.Rule, v.Type())
+	}
+	if ruleDef.Name != node.Rule {
+		return fmt.Errorf("rule %s found for value of type %s", node.Rule, ruleDef.Name)
+	}
+	for _, field := range node.Fields {
+		ruleField, ok := ruleDef.field(field.Name)
+		if !ok {
+			return fmt.Errorf("rule %s has no field %s", ruleDef.Name, field.Name)
+		}
+		fieldV := v.Field(ruleField.Index)
+		switch {
+		case ruleField.Pointer:
+			ptrV := reflect.New(ruleField.BaseType)
+			err = unmarshalNode(field.Node, ptrV.Elem())
+			fieldV.Set(ptrV)
+		case ruleField.Array:
+			itemsV := reflect.MakeSlice(fieldV.Type(), len(field.Items), len(field.Items))
+			for i, item := range field.Items {
+				if err = unmarshalNode(item, itemsV.Index(i)); err != nil {
+					break
+				}
+			}
+			fieldV.Set(itemsV)
+		default:
+			err = unmarshalNode(field.Node, fieldV)
+		}
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", ruleDef.Name, field.Name, err)
+		}
+	}
+	return nil
+}
+
+// unmarshalToken loads v with a token, which it does by parsing a token stream
+// containing only that token so that it works with any token type.
+func unmarshalToken(tok *treeToken, v reflect.Value) error {
+	ptr, ok := v.Addr().Interface().(Parser)
+	if !ok {
+		return fmt.Errorf("token found for value of type %s", v.Type())
+	}
+	s := &ParserState{
+		TokenStream: NewSimpleTokenStream([]Token{SimpleToken{TokType: tok.Type, TokValue: tok.Value}}),
+	}
+	anyToken := TokenOptions{TokenParseOptions: []TokenParseOptions{{}}}
+	if err := ParseWithOptions(ptr, s, anyToken); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d *RuleDef) field(name string) (RuleField, bool) {
+	for _, f := range d.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return RuleField{}, false
+}
+
+const sexprMaxWidth = 80
+
+// writeSExprNode writes a node on one line if it fits, otherwise each field
+// and each item of repeated fields goes on its own line.
+func writeSExprNode(b *bytes.Buffer, node *treeNode, indent int) {
+	flat := flatSExprNode(node)
+	if node.Token != nil || indent+len(flat) <= sexprMaxWidth {
+		b.WriteString(flat)
+		return
+	}
+	b.WriteByte('(')
+	b.WriteString(sexprName(node.Rule))
+	for _, field := range node.Fields {
+		writeSExprIndent(b, indent+2)
+		b.WriteString(field.Name)
+		b.WriteString(": ")
+		if field.Node != nil {
+			writeSExprNode(b, field.Node, indent+2)
+			continue
+		}
+		b.WriteByte('[')
+		for _, item := range field.Items {
+			writeSExprIndent(b, indent+4)
+			writeSExprNode(b, item, indent+4)
+		}
+		b.WriteByte(']')
+	}
+	b.WriteByte(')')
+}
+
+func writeSExprIndent(b *bytes.Buffer, indent int) {
+	b.WriteByte('\n')
+	b.WriteString(strings.Repeat(" ", indent))
+}
+
+func flatSExprNode(node *treeNode) string {
+	if node.Token != nil {
+		return fmt.Sprintf("(%s %s)", sexprName(node.Token.Type), strconv.Quote(node.Token.Value))
+	}
+	parts := []string{sexprName(node.Rule)}
+	for _, field := range node.Fields {
+		if field.Node != nil {
+			parts = append(parts, field.Name+":", flatSExprNode(field.Node))
+			continue
+		}
+		items := make([]string, len(field.Items))
+		for i, item := range field.Items {
+			items[i] = flatSExprNode(item)
+		}
+		parts = append(parts, field.Name+":", "["+strings.Join(items, " ")+"]")
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+// sexprName returns name if it can be written without quotes, otherwise a
+// quoted version of name.
+func sexprName(name string) string {
+	if name == "" {
+		return `""`
+	}
+	for i := 0; i < len(name); i++ {
+		if !isSExprNameChar(name[i]) {
+			return strconv.Quote(name)
+		}
+	}
+	return name
+}
+
+func isSExprNameChar(c byte) bool {
+	return c == '_' || c == '-' || c == '.' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+type sexprParser struct {
+	src []byte
+	pos int
+}
+
+func (p *sexprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid tree at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *sexprParser) skipSpace() {
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *sexprParser) consume(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.src) && p.src[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sexprParser) parseTree() (*treeNode, error) {
+	node, err := p.parseNode()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos < len(p.src) {
+		return nil, p.errorf("unexpected data after tree")
+	}
+	return node, nil
+}
+
+func (p *sexprParser) parseNode() (*treeNode, error) {
+	if !p.consume('(') {
+		return nil, p.errorf("expected (")
+	}
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	node := &treeNode{}
+	if p.skipSpace(); p.pos < len(p.src) && p.src[p.pos] == '"' {
+		value, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		node.Token = &treeToken{Type: name, Value: value}
+	} else {
+		node.Rule = name
+		for !p.consume(')') {
+			field, err := p.parseField()
+			if err != nil {
+				return nil, err
+			}
+			node.Fields = append(node.Fields, field)
+		}
+		return node, nil
+	}
+	if !p.consume(')') {
+		return nil, p.errorf("expected )")
+	}
+	return node, nil
+}
+
+func (p *sexprParser) parseField() (field treeField, err error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && isSExprNameChar(p.src[p.pos]) {
+		p.pos++
+	}
+	field.Name = string(p.src[start:p.pos])
+	if field.Name == "" || !p.consume(':') {
+		return field, p.errorf("expected field name followed by :")
+	}
+	if !p.consume('[') {
+		field.Node, err = p.parseNode()
+		return
+	}
+	for !p.consume(']') {
+		var item *treeNode
+		if item, err = p.parseNode(); err != nil {
+			return
+		}
+		field.Items = append(field.Items, item)
+	}
+	return
+}
+
+func (p *sexprParser) parseName() (string, error) {
+	if p.skipSpace(); p.pos < len(p.src) && p.src[p.pos] == '"' {
+		return p.parseString()
+	}
+	start := p.pos
+	for p.pos < len(p.src) && isSExprNameChar(p.src[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expected name")
+	}
+	return string(p.src[start:p.pos]), nil
+}
+
+func (p *sexprParser) parseString() (string, error) {
+	start := p.pos
+	for i := start + 1; i < len(p.src); i++ {
+		switch p.src[i] {
+		case '\\':
+			i++
+		case '"':
+			p.pos = i + 1
+			s, err := strconv.Unquote(string(p.src[start:p.pos]))
+			if err != nil {
+				return "", p.errorf("invalid string: %s", err)
+			}
+			return s, nil
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
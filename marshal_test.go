@@ -0,0 +1,167 @@
+package grammar
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarshalTree(t *testing.T) {
+	expr := mustParseTestExpr(t, "(a (b 1) ())")
+	tests := []struct {
+		name   string
+		format TreeFormat
+		want   string
+	}{
+		{
+			name:   "sexpr",
+			format: SExprTree,
+			want: `(testExpr
+  List: (testList
+    Items: [
+      (testExpr Atom: (atom "a"))
+      (testExpr
+        List: (testList
+          Items: [
+            (testExpr Atom: (atom "b"))
+            (testExpr Number: (number "1"))]))
+      (testExpr List: (testList))]))
+`,
+		},
+		{
+			name:   "json",
+			format: JSONTree,
+			want: `{
+  "rule": "testExpr",
+  "fields": [
+    {
+      "name": "List",
+      "node": {
+        "rule": "testList",
+        "fields": [
+          {
+            "name": "Items",
+            "items": [
+              {
+                "rule": "testExpr",
+                "fields": [
+                  {
+                    "name": "Atom",
+                    "node": {
+                      "token": {
+                        "type": "atom",
+                        "value": "a"
+                      }
+                    }
+                  }
+                ]
+              },
+              {
+                "rule": "testExpr",
+                "fields": [
+                  {
+                    "name": "List",
+                    "node": {
+                      "rule": "testList",
+                      "fields": [
+                        {
+                          "name": "Items",
+                          "items": [
+                            {
+                              "rule": "testExpr",
+                              "fields": [
+                                {
+                                  "name": "Atom",
+                                  "node": {
+                                    "token": {
+                                      "type": "atom",
+                                      "value": "b"
+                                    }
+                                  }
+                                }
+                              ]
+                            },
+                            {
+                              "rule": "testExpr",
+                              "fields": [
+                                {
+                                  "name": "Number",
+                                  "node": {
+                                    "token": {
+                                      "type": "number",
+                                      "value": "1"
+                                    }
+                                  }
+                                }
+                              ]
+                            }
+                          ]
+                        }
+                      ]
+                    }
+                  }
+                ]
+              },
+              {
+                "rule": "testExpr",
+                "fields": [
+                  {
+                    "name": "List",
+                    "node": {
+                      "rule": "testList"
+                    }
+                  }
+                ]
+              }
+            ]
+          }
+        ]
+      }
+    }
+  ]
+}`,
+		},
+	}
+	rules, err := NewRuleRegistry(testExpr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := MarshalTree(expr, tt.format)
+			if err != nil {
+				t.Fatalf("Error marshalling: %s", err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("Got:\n%s\nWant:\n%s", data, tt.want)
+			}
+			got, err := UnmarshalTree(data, tt.format, rules)
+			if err != nil {
+				t.Fatalf("Error unmarshalling: %s", err)
+			}
+			if !reflect.DeepEqual(got, expr) {
+				t.Errorf("Unmarshalled %+v, want %+v", got, expr)
+			}
+		})
+	}
+}
+
+func TestUnmarshalTreeErrors(t *testing.T) {
+	rules, err := NewRuleRegistry(testExpr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range []string{
+		`(testExpr`,
+		`(unknown)`,
+		`(testExpr List: (testExpr))`,
+		`(testExpr Missing: (atom "a"))`,
+		`(testExpr Atom: (testList))`,
+		`(atom "a")`,
+		`(testExpr) x`,
+		`(testExpr Atom: (atom "a)`,
+	} {
+		if _, err := UnmarshalTree([]byte(src), SExprTree, rules); err == nil {
+			t.Errorf("Expected error unmarshalling %s", src)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package grammar
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// namedRules maps the names of the rule types registered with RegisterRule to
+// the types.  It is guarded by namedRulesMu so that rules can be registered
+// while other rules are being parsed.
+var (
+	namedRules   = map[string]reflect.Type{}
+	namedRulesMu sync.RWMutex
+)
+
+// RegisterRule registers the type of the rule r under its name, so that it can
+// be used as a separator in sep and term tags as @Name, e.g.
+//
+//	grammar.MustRegisterRule(StmtSep{})
+//
+//	type Block struct {
+//	    grammar.Seq
+//	    Open  grammar.Match `tok:"op,{"`
+//	    Stmts []Stmt        `sep:"@StmtSep"`
+//	    Close grammar.Match `tok:"op,}"`
+//	}
+//
+// Go cannot find a type from its name, hence the registration.  As rule
+// definitions are cached, a rule must be registered before the rules using it
+// are parsed for the first time, e.g. in an init function.
+func RegisterRule(r interface{}) error {
+	tp := reflect.TypeOf(r)
+	if tp == nil {
+		return fmt.Errorf("cannot register nil rule")
+	}
+	ruleDef, err := getRuleDef(tp)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid rule: %w", tp, err)
+	}
+	namedRulesMu.Lock()
+	defer namedRulesMu.Unlock()
+	if _, ok := namedRules[ruleDef.Name]; ok {
+		return fmt.Errorf("rule %s already registered", ruleDef.Name)
+	}
+	namedRules[ruleDef.Name] = tp
+	return nil
+}
+
+// MustRegisterRule is like RegisterRule but panics if the rule cannot be
+// registered.
+func MustRegisterRule(r interface{}) {
+	if err := RegisterRule(r); err != nil {
+		panic(err)
+	}
+}
+
+func lookupRule(name string) (reflect.Type, bool) {
+	namedRulesMu.RLock()
+	defer namedRulesMu.RUnlock()
+	tp, ok := namedRules[name]
+	return tp, ok
+}
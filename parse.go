@@ -8,16 +8,53 @@ import (
 
 type ParserState struct {
 	TokenStream
-	lastErr *ParseError
-	depth   int
-	logger  *log.Logger
+	lastErr   *ParseError
+	depth     int
+	logger    *log.Logger
+	spans     Spans
+	path      Path
+	coverage  *Coverage
+	tracer    Tracer
+	limits    *parseLimits
+	aborted   *ParseError
+	iterative bool
+	audit     *AmbiguityAudit
+
+	userState    interface{}
+	stateJournal []stateChange
 }
 
 func (s *ParserState) MergeError(err *ParseError) *ParseError {
+	if s.tracer != nil {
+		s.trace(TraceEvent{Kind: TraceMergeError, Err: err, Pos: err.Pos})
+	}
 	s.lastErr = s.lastErr.Merge(err)
 	return s.lastErr
 }
 
+// Save returns the current position in the token stream.
+func (s *ParserState) Save() int {
+	pos := s.TokenStream.Save()
+	if s.tracer != nil {
+		s.trace(TraceEvent{Kind: TraceSave, Pos: pos})
+	}
+	return pos
+}
+
+// Restore returns the token stream to the given position and rolls back the
+// changes made to the user state after it (changes made at that position are
+// kept).  The Save and Restore methods of the parser state should be used
+// rather than those of the underlying token stream so that they can be traced.
+func (s *ParserState) Restore(pos int) {
+	if s.tracer != nil {
+		s.trace(TraceEvent{Kind: TraceRestore, Pos: pos, From: s.TokenStream.Save()})
+	}
+	s.TokenStream.Restore(pos)
+	if len(s.stateJournal) > 0 {
+		s.rollbackUserStateAfter(pos)
+	}
+}
+
 func (s *ParserState) Debug() bool {
 	return s.logger != nil
 }
@@ -56,17 +93,44 @@ type TokenStream interface {
 
 type ParseOption func(s *ParserState)
 
+// WithLogger makes the parser log the rules and fields it tries to l (see
+// NewLogTracer).
 func WithLogger(l *log.Logger) ParseOption {
 	return func(s *ParserState) {
 		s.logger = l
+		s.addTracer(NewLogTracer(l))
 	}
 }
 
 var WithDefaultLogger = WithLogger(log.Default())
 
+// WithSpans makes the parser record the span of each node in the parse tree
+// into spans.
+func WithSpans(spans Spans) ParseOption {
+	return func(s *ParserState) {
+		s.spans = spans
+	}
+}
+
+// Spans maps the path of nodes in a parse tree (as returned by Path.String())
+// to the span of tokens they matched.
+type Spans map[string]Span
+
+// A Span is a range of positions in a token stream, from Start (inclusive) to
+// End (exclusive).
+type Span struct {
+	Start, End int
+}
+
+func (s Span) String() string {
+	return fmt.Sprintf("@%d-%d", s.Start, s.End)
+}
+
 // Parse tries to interpret dest as a grammar rule and use it to parse the given
 // token stream.  Parse can panic if dest is not a valid grammar rule.  It
-// returns a non-nil *ParseError if the token stream does not match the rule.
+// returns a non-nil *ParseError if the token stream does not match the rule,
+// or if parsing was aborted because a limit set by an option was exceeded (see
+// WithContext, WithMaxDepth, WithMaxTokens and WithMaxSteps).
 func Parse(dest interface{}, s TokenStream, opts ...ParseOption) *ParseError {
 	state := &ParserState{
 		TokenStream: s,
@@ -74,10 +138,20 @@ func Parse(dest interface{}, s TokenStream, opts ...ParseOption) *ParseError {
 	for _, opt := range opts {
 		opt(state)
 	}
+	start := state.Save()
 	err := ParseWithOptions(dest, state, TokenOptions{})
+	if state.aborted != nil {
+		return state.aborted
+	}
+	if err != nil && err.Committed {
+		return err
+	}
 	if err != nil {
 		return state.lastErr
 	}
+	if state.spans != nil {
+		state.spans[""] = Span{Start: start, End: state.Save()}
+	}
 	return nil
 }
 
@@ -86,29 +160,58 @@ func Parse(dest interface{}, s TokenStream, opts ...ParseOption) *ParseError {
 func ParseWithOptions(dest interface{}, s *ParserState, opts TokenOptions) *ParseError {
 	switch p := dest.(type) {
 	case Parser:
-		if s.Debug() {
-			s.Logf("===> %T, %v", p, opts)
+		if s.iterative && isRule(dest) {
+			return s.parseIterative(dest, opts)
 		}
-		s.depth++
-		err := p.Parse(dest, s, opts)
-		s.depth--
+		rule, err := s.enterRule(dest, opts)
 		if err != nil {
-			s.MergeError(err)
-		}
-		if s.Debug() {
-			s.Logf("<=== %s", err)
+			return err
 		}
+		err = p.Parse(dest, s, opts)
+		s.exitRule(dest, rule, err)
 		return err
 	default:
 		panic(fmt.Sprintf("invalid type for rule %#v", dest))
 	}
 }
 
+// enterRule is called before parsing dest.  It returns a non-nil error if
+// parsing should be aborted.  The rule name is only computed if tracing is
+// enabled.
+func (s *ParserState) enterRule(dest interface{}, opts TokenOptions) (string, *ParseError) {
+	if err := s.checkLimits(); err != nil {
+		return "", err
+	}
+	var rule string
+	if s.tracer != nil {
+		rule = ruleName(dest)
+		s.trace(TraceEvent{Kind: TraceEnterRule, Dest: dest, Rule: rule, Options: opts, Pos: s.TokenStream.Save()})
+	}
+	s.depth++
+	return rule, nil
+}
+
+// exitRule is called after parsing dest, with the result.
+func (s *ParserState) exitRule(dest interface{}, rule string, err *ParseError) {
+	s.depth--
+	if err != nil {
+		s.MergeError(err)
+	}
+	if s.tracer != nil {
+		s.trace(TraceEvent{Kind: TraceExitRule, Dest: dest, Rule: rule, Err: err, Pos: s.TokenStream.Save()})
+	}
+}
+
 type ParseError struct {
 	Err error
 	Token
 	TokenParseOptions []TokenParseOptions
 	Pos               int
+
+	// Committed is true if the error occurred after a Commit field in a Seq
+	// rule, in which case it is returned by Parse rather than the furthest
+	// error.
+	Committed bool
 }
 
 func (e *ParseError) Error() string {
@@ -117,8 +220,20 @@ func (e *ParseError) Error() string {
 		hint = e.Err.Error()
 	} else if len(e.TokenParseOptions) != 0 {
 		var b strings.Builder
-		b.WriteString("expected token with ")
-		types, values := summariseOptions(e.TokenParseOptions)
+		b.WriteString("expected ")
+		classes, types, values := summariseOptions(e.TokenParseOptions)
+		for i, c := range classes {
+			if i > 0 {
+				b.WriteString(" or ")
+			}
+			b.WriteString(c)
+		}
+		if len(classes) > 0 && len(types)+len(values) > 0 {
+			b.WriteString(", or ")
+		}
+		if len(types)+len(values) > 0 {
+			b.WriteString("token with ")
+		}
 		if len(types) > 0 {
 			b.WriteString("type ")
 			for i, t := range types {
@@ -137,7 +252,7 @@ func (e *ParseError) Error() string {
 				if i > 0 {
 					b.WriteString(" or ")
 				}
-				fmt.Fprintf(&b, "%q", v)
+				b.WriteString(v)
 			}
 		}
 		hint = b.String()
@@ -145,24 +260,38 @@ func (e *ParseError) Error() string {
 	return fmt.Sprintf("token #%d %s with value %q: %s", e.Pos, e.Token.Type(), e.Token.Value(), hint)
 }
 
-func summariseOptions(opts []TokenParseOptions) ([]string, []string) {
+// summariseOptions returns the token classes, types and value labels (see
+// TokenParseOptions.valueLabel) in opts without duplicates, in the order they
+// first appear so that error messages are stable.
+func summariseOptions(opts []TokenParseOptions) ([]string, []string, []string) {
+	seenClasses := map[string]struct{}{}
 	seenTypes := map[string]struct{}{}
 	seenValues := map[string]struct{}{}
+	var classes, types, values []string
 	for _, opt := range opts {
-		if opt.TokenValue != "" {
-			seenValues[opt.TokenValue] = struct{}{}
+		if opt.Class != "" {
+			if _, ok := seenClasses[opt.Class]; !ok {
+				seenClasses[opt.Class] = struct{}{}
+				classes = append(classes, opt.Class)
+			}
+		} else if label := opt.valueLabel(); label != "" {
+			if _, ok := seenValues[label]; !ok {
+				seenValues[label] = struct{}{}
+				values = append(values, label)
+			}
 		} else if opt.TokenType != "" {
-			seenTypes[opt.TokenType] = struct{}{}
+			if _, ok := seenTypes[opt.TokenType]; !ok {
+				seenTypes[opt.TokenType] = struct{}{}
+				types = append(types, opt.TokenType)
+			}
 		}
 	}
-	var types, values []string
-	for t := range seenTypes {
-		types = append(types, t)
-	}
-	for v := range seenValues {
-		values = append(values, v)
-	}
-	return types, values
+	return classes, types, values
+}
+
+// Unwrap returns the underlying error, if any.
+func (e *ParseError) Unwrap() error {
+	return e.Err
 }
 
 func (e *ParseError) Merge(e2 *ParseError) *ParseError {
@@ -178,9 +307,17 @@ func (e *ParseError) Merge(e2 *ParseError) *ParseError {
 	if e.Pos < e2.Pos {
 		return e2
 	}
-	return &ParseError{
+	merged := &ParseError{
 		Token:             e.Token,
 		TokenParseOptions: append(e.TokenParseOptions, e2.TokenParseOptions...),
 		Pos:               e.Pos,
 	}
+	// Keep a custom error if there are no expected tokens to report instead.
+	if len(merged.TokenParseOptions) == 0 {
+		merged.Err = e.Err
+		if merged.Err == nil {
+			merged.Err = e2.Err
+		}
+	}
+	return merged
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"strconv"
 	"strings"
 )
 
@@ -28,47 +29,249 @@ import (
 //
 // Empty optional fields and empty repeated fields are omitted altogether.
 func PrettyWrite(out io.Writer, r interface{}) error {
-	return prettyWrite(out, r, "", 0)
+	return PrettyWriteWithOptions(out, r, PrettyOptions{})
 }
 
-func prettyWrite(out io.Writer, r interface{}, pfx string, indent int) (err error) {
-	ruleDef, err := getRuleDef(reflect.TypeOf(r))
-	rV := reflect.ValueOf(r)
-	if err != nil {
-		return writeLine(out, pfx, indent, r)
+// PrettyOptions control the output of PrettyWriteWithOptions.  The zero value
+// gives the output of PrettyWrite.
+type PrettyOptions struct {
+	HideMatches bool  // Omit fields with no content, such as Match and Empty fields
+	MaxDepth    int   // If positive, the contents of rules nested deeper are elided
+	Spans       Spans // If not nil, the span of each node is output (see WithSpans)
+	Compact     bool  // Output the whole tree on a single line
+	GoLiteral   bool  // Output the tree as a Go composite literal
+}
+
+// PrettyWriteWithOptions is like PrettyWrite, but the output can be customised
+// with opts.  With the GoLiteral option the output is valid Go syntax, e.g.
+//
+//	&Json{
+//		Array: &Array{
+//			Items: []Json{
+//				{
+//					Number: &Number{
+//						Value: grammar.SimpleToken{TokType: "number", TokValue: "1"},
+//					},
+//				},
+//			},
+//		},
+//	}
+//
+// Types are qualified with their package name unless they belong to the same
+// package as r.  Fields with zero values are always omitted in this format.
+func PrettyWriteWithOptions(out io.Writer, r interface{}, opts PrettyOptions) error {
+	v := reflect.ValueOf(r)
+	if !v.IsValid() {
+		return fmt.Errorf("cannot write nil value")
 	}
-	err = writeLine(out, pfx, indent, ruleDef.Name+" {")
-	if err != nil {
+	p := prettyPrinter{out: out, opts: opts}
+	tp := v.Type()
+	if tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	p.pkgPath = tp.PkgPath()
+	p.writeValue(v, 0, 0, false)
+	p.write("\n")
+	return p.err
+}
+
+type prettyPrinter struct {
+	out     io.Writer
+	opts    PrettyOptions
+	pkgPath string // The package that types do not need to be qualified with
+	path    Path
+	err     error
+}
+
+func (p *prettyPrinter) write(s string) {
+	if p.err == nil {
+		_, p.err = io.WriteString(p.out, s)
+	}
+}
+
+// newline starts a new line at the given indentation level, or writes a
+// separator in compact mode.
+func (p *prettyPrinter) newline(level int, first bool) {
+	switch {
+	case !p.opts.Compact:
+		if p.opts.GoLiteral {
+			p.write("\n" + strings.Repeat("\t", level))
+		} else {
+			p.write("\n" + strings.Repeat("  ", level))
+		}
+	case !first:
+		p.write(", ")
+	}
+}
+
+func (p *prettyPrinter) writeSpan() {
+	if p.opts.Spans == nil {
 		return
 	}
-	for _, ruleField := range ruleDef.Fields {
-		fieldV := rV.Field(ruleField.Index)
-		switch {
-		case ruleField.Pointer:
-			if !fieldV.IsNil() {
-				prettyWrite(out, fieldV.Elem().Interface(), ruleField.Name, indent+2)
+	span, ok := p.opts.Spans[p.path.String()]
+	if !ok {
+		return
+	}
+	if p.opts.GoLiteral {
+		p.write(" /* " + span.String() + " */")
+	} else {
+		p.write(" " + span.String())
+	}
+}
+
+// writeValue writes the value v, which may be a pointer, at the given
+// indentation level.  In Go literal mode, if elided is true then the type of
+// v can be omitted.
+func (p *prettyPrinter) writeValue(v reflect.Value, level, depth int, elided bool) {
+	isPtr := v.Kind() == reflect.Ptr
+	if isPtr {
+		v = v.Elem()
+	}
+	ruleDef, err := getRuleDef(v.Type())
+	if err != nil {
+		if p.opts.GoLiteral {
+			if isPtr && !elided {
+				p.write("&")
 			}
-		case ruleField.Array:
-			if fieldV.Len() > 0 {
-				writeLine(out, ruleField.Name, indent+2, "[")
-				for i := 0; i < fieldV.Len(); i++ {
-					prettyWrite(out, fieldV.Index(i).Interface(), "", indent+4)
-				}
-				writeLine(out, "", indent+2, "]")
+			p.write(p.goLiteral(v, elided))
+			p.writeSpan()
+		} else {
+			p.write(fmt.Sprintf("%v", v.Interface()))
+			p.writeSpan()
+		}
+		return
+	}
+	if p.opts.GoLiteral {
+		if !elided {
+			if isPtr {
+				p.write("&")
 			}
-		default:
-			prettyWrite(out, fieldV.Interface(), ruleField.Name, indent+2)
+			p.write(p.typeName(v.Type()))
+		}
+		p.write("{")
+		p.writeSpan()
+	} else {
+		p.write(ruleDef.Name)
+		p.writeSpan()
+		p.write(" {")
+	}
+	if p.opts.MaxDepth > 0 && depth >= p.opts.MaxDepth {
+		if p.opts.GoLiteral {
+			p.write(" /* ... */ }")
+		} else {
+			p.write("...}")
 		}
+		return
 	}
-	writeLine(out, "", indent, "}")
-	return nil
+	first := true
+	for _, ruleField := range ruleDef.Fields {
+		fieldV := v.Field(ruleField.Index)
+		if !p.showField(ruleField, fieldV) {
+			continue
+		}
+		p.newline(level+1, first)
+		first = false
+		p.write(ruleField.Name + ": ")
+		p.path = append(p.path, PathStep{Rule: ruleDef.Name, Field: ruleField.Name, Index: -1})
+		if ruleField.Array {
+			p.writeItems(fieldV, level+1, depth+1)
+		} else {
+			p.writeValue(fieldV, level+1, depth+1, false)
+		}
+		p.path = p.path[:len(p.path)-1]
+		if p.opts.GoLiteral && !p.opts.Compact {
+			p.write(",")
+		}
+	}
+	if !p.opts.Compact && (!first || !p.opts.GoLiteral) {
+		p.newline(level, false)
+	}
+	p.write("}")
 }
 
-func writeLine(out io.Writer, pfx string, indent int, val interface{}) (err error) {
-	if pfx != "" {
-		_, err = fmt.Fprintf(out, "%s%s: %v\n", strings.Repeat(" ", indent), pfx, val)
+func (p *prettyPrinter) writeItems(itemsV reflect.Value, level, depth int) {
+	if p.opts.GoLiteral {
+		p.write(p.typeName(itemsV.Type()) + "{")
+	} else {
+		p.write("[")
+	}
+	last := len(p.path) - 1
+	for i := 0; i < itemsV.Len(); i++ {
+		p.newline(level+1, i == 0)
+		p.path[last].Index = i
+		p.writeValue(itemsV.Index(i), level+1, depth, true)
+		if p.opts.GoLiteral && !p.opts.Compact {
+			p.write(",")
+		}
+	}
+	p.newline(level, true)
+	if p.opts.GoLiteral {
+		p.write("}")
 	} else {
-		_, err = fmt.Fprintf(out, "%s%v\n", strings.Repeat(" ", indent), val)
+		p.write("]")
+	}
+}
+
+// showField returns true if the field should be output.
+func (p *prettyPrinter) showField(ruleField RuleField, fieldV reflect.Value) bool {
+	switch {
+	case ruleField.Lookahead != NoLookahead:
+		return false
+	case ruleField.Pointer:
+		return !fieldV.IsNil()
+	case ruleField.Array:
+		return fieldV.Len() > 0
+	case p.opts.GoLiteral:
+		return !fieldV.IsZero()
+	case p.opts.HideMatches:
+		_, err := getRuleDef(fieldV.Type())
+		return err == nil || fieldV.Type().Size() > 0
+	default:
+		return true
+	}
+}
+
+// typeName returns the name of tp as it should be written in a Go literal.
+func (p *prettyPrinter) typeName(tp reflect.Type) string {
+	switch tp.Kind() {
+	case reflect.Ptr:
+		return "*" + p.typeName(tp.Elem())
+	case reflect.Slice:
+		return "[]" + p.typeName(tp.Elem())
+	}
+	qualify := func(pkgPath string) string {
+		if pkgPath == "" || pkgPath == p.pkgPath {
+			return ""
+		}
+		return pkgPath[strings.LastIndexByte(pkgPath, '/')+1:] + "."
+	}
+	if tp.Name() == "" {
+		return tp.String()
+	}
+	// Type arguments of generic types are qualified in the same way.
+	return qualify(tp.PkgPath()) + qualifyTypeNames(tp.Name(), qualify)
+}
+
+// goLiteral returns a Go literal for a value which is not a rule.
+func (p *prettyPrinter) goLiteral(v reflect.Value, elided bool) string {
+	switch v.Kind() {
+	case reflect.Struct:
+		var fields []string
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" || v.Field(i).IsZero() {
+				continue
+			}
+			fields = append(fields, field.Name+": "+p.goLiteral(v.Field(i), false))
+		}
+		lit := "{" + strings.Join(fields, ", ") + "}"
+		if elided {
+			return lit
+		}
+		return p.typeName(v.Type()) + lit
+	case reflect.String:
+		return strconv.Quote(v.String())
+	default:
+		return fmt.Sprintf("%#v", v.Interface())
 	}
-	return
 }
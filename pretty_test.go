@@ -0,0 +1,121 @@
+package grammar
+
+import (
+	"errors"
+	"go/parser"
+	"strings"
+	"testing"
+)
+
+func TestPrettyWriteWithOptions(t *testing.T) {
+	stream, err := testTokenise("(a (1))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans := Spans{}
+	var expr testExpr
+	if err := Parse(&expr, stream, WithSpans(spans)); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		r    interface{}
+		opts PrettyOptions
+		want string
+	}{
+		{
+			name: "compact",
+			r:    expr,
+			opts: PrettyOptions{Compact: true},
+			want: `testExpr {List: testList {Open: {}, Items: [testExpr {Atom: {atom a}}, testExpr {List: testList {Open: {}, Items: [testExpr {Number: {number 1}}], Close: {}}}], Close: {}}}
+`,
+		},
+		{
+			name: "hide matches and max depth",
+			r:    expr,
+			opts: PrettyOptions{HideMatches: true, MaxDepth: 3},
+			want: `testExpr {
+  List: testList {
+    Items: [
+      testExpr {
+        Atom: {atom a}
+      }
+      testExpr {
+        List: testList {...}
+      }
+    ]
+  }
+}
+`,
+		},
+		{
+			name: "spans",
+			r:    expr,
+			opts: PrettyOptions{Spans: spans, HideMatches: true, Compact: true},
+			want: `testExpr @0-6 {List: testList @0-6 {Items: [testExpr @1-2 {Atom: {atom a} @1-2}, testExpr @2-5 {List: testList @2-5 {Items: [testExpr @3-4 {Number: {number 1} @3-4}]}}]}}
+`,
+		},
+		{
+			name: "go literal",
+			r:    &expr,
+			opts: PrettyOptions{GoLiteral: true},
+			want: `&testExpr{
+	List: &testList{
+		Items: []testExpr{
+			{
+				Atom: &SimpleToken{TokType: "atom", TokValue: "a"},
+			},
+			{
+				List: &testList{
+					Items: []testExpr{
+						{
+							Number: &SimpleToken{TokType: "number", TokValue: "1"},
+						},
+					},
+				},
+			},
+		},
+	},
+}
+`,
+		},
+		{
+			name: "compact go literal",
+			r:    expr,
+			opts: PrettyOptions{GoLiteral: true, Compact: true, MaxDepth: 3},
+			want: `testExpr{List: &testList{Items: []testExpr{{Atom: &SimpleToken{TokType: "atom", TokValue: "a"}}, {List: &testList{ /* ... */ }}}}}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := PrettyWriteWithOptions(&b, tt.r, tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("Got:\n%s\nWant:\n%s", b.String(), tt.want)
+			}
+			if tt.opts.GoLiteral {
+				if _, err := parser.ParseExpr(b.String()); err != nil {
+					t.Errorf("Invalid Go literal: %s", err)
+				}
+			}
+		})
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errWriteFailed
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func TestPrettyWriteReturnsErrors(t *testing.T) {
+	expr := mustParseTestExpr(t, "(a (b))")
+	if err := PrettyWrite(failingWriter{}, expr); err == nil {
+		t.Error("Expected write error")
+	}
+}
@@ -0,0 +1,368 @@
+package grammar
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// A Profile records, across any number of parses, how many times each rule
+// was tried, how many tokens were backtracked over and how much time was
+// spent.  Pass it to Parse with WithProfile, then report the results with
+// WriteTable or WritePprof.  It is safe to use the same Profile in concurrent
+// parses.
+//
+// Tokens are profiled as well as rules, under the name of their Go type (e.g.
+// SimpleToken or Match).
+type Profile struct {
+	mu      sync.Mutex
+	start   time.Time
+	rules   map[string]*RuleProfile
+	samples map[string]*profileSample
+}
+
+// RuleProfile is the profile of a rule.
+type RuleProfile struct {
+	Name      string
+	Attempts  int // Number of times the rule was tried
+	Successes int // Number of times the rule matched
+	Failures  int // Number of times the rule did not match
+
+	// Number of tokens that were consumed while parsing the rule and had to
+	// be given back to the token stream by a call to Restore, so that they
+	// were read again.
+	Backtracked int
+
+	Self       time.Duration // Time spent in the rule itself, excluding nested rules
+	Cumulative time.Duration // Time spent in the rule, including nested rules
+}
+
+// A profileSample accumulates the values for one stack of rules.
+type profileSample struct {
+	stack  []string // Innermost rule first
+	values [profileValueCount]int64
+}
+
+// The values recorded in profile samples, in the order of profileSampleTypes.
+const (
+	profileAttempts = iota
+	profileFailures
+	profileBacktracked
+	profileTime
+	profileValueCount
+)
+
+var profileSampleTypes = [profileValueCount][2]string{
+	{"attempts", "count"},
+	{"failures", "count"},
+	{"backtracked", "tokens"},
+	{"time", "nanoseconds"},
+}
+
+// NewProfile returns a new empty Profile.
+func NewProfile() *Profile {
+	return &Profile{
+		start:   time.Now(),
+		rules:   map[string]*RuleProfile{},
+		samples: map[string]*profileSample{},
+	}
+}
+
+// WithProfile makes the parser record profiling data into p.
+func WithProfile(p *Profile) ParseOption {
+	return func(s *ParserState) {
+		s.addTracer(&profiler{profile: p, active: map[string]int{}})
+	}
+}
+
+// profiler is a Tracer which keeps track of the stack of rules being parsed
+// for a single parse.
+type profiler struct {
+	profile *Profile
+	stack   []profilerFrame
+	active  map[string]int // Number of frames in the stack for each rule
+}
+
+type profilerFrame struct {
+	name        string
+	start       time.Time
+	nested      time.Duration // Time spent in nested rules
+	backtracked int
+}
+
+func (p *profiler) Trace(ev TraceEvent) {
+	switch ev.Kind {
+	case TraceEnterRule:
+		p.enter(ev.Rule)
+	case TraceExitRule:
+		p.exit(ev.Err == nil)
+	case TraceRestore:
+		p.restore(ev.From - ev.Pos)
+	}
+}
+
+func (p *profiler) enter(name string) {
+	p.active[name]++
+	p.stack = append(p.stack, profilerFrame{name: name, start: time.Now()})
+}
+
+func (p *profiler) exit(success bool) {
+	last := len(p.stack) - 1
+	frame := p.stack[last]
+	elapsed := time.Since(frame.start)
+	if last > 0 {
+		p.stack[last-1].nested += elapsed
+	}
+	p.active[frame.name]--
+	// Recursive rules only count time in their outermost frame.
+	outermost := p.active[frame.name] == 0
+	stack := make([]string, len(p.stack))
+	for i, f := range p.stack {
+		stack[last-i] = f.name
+	}
+	p.stack = p.stack[:last]
+	p.profile.record(stack, success, frame.backtracked, elapsed-frame.nested, elapsed, outermost)
+}
+
+func (p *profiler) restore(count int) {
+	if len(p.stack) > 0 && count > 0 {
+		p.stack[len(p.stack)-1].backtracked += count
+	}
+}
+
+// ruleName returns the name of the rule dest points to, or the name of its Go
+// type if it is not a rule.
+func ruleName(dest interface{}) string {
+	tp := reflect.TypeOf(dest)
+	if tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	if ruleDef, err := getRuleDef(tp); err == nil {
+		return ruleDef.Name
+	}
+	return tp.Name()
+}
+
+func (p *Profile) record(stack []string, success bool, backtracked int, self, cumulative time.Duration, outermost bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	name := stack[0]
+	rule, ok := p.rules[name]
+	if !ok {
+		rule = &RuleProfile{Name: name}
+		p.rules[name] = rule
+	}
+	rule.Attempts++
+	if success {
+		rule.Successes++
+	} else {
+		rule.Failures++
+	}
+	rule.Backtracked += backtracked
+	rule.Self += self
+	if outermost {
+		rule.Cumulative += cumulative
+	}
+	key := strings.Join(stack, ";")
+	sample, ok := p.samples[key]
+	if !ok {
+		sample = &profileSample{stack: stack}
+		p.samples[key] = sample
+	}
+	sample.values[profileAttempts]++
+	if !success {
+		sample.values[profileFailures]++
+	}
+	sample.values[profileBacktracked] += int64(backtracked)
+	sample.values[profileTime] += int64(self)
+}
+
+// Rules returns a copy of the profiles of all the rules, sorted by decreasing
+// cumulative time.
+func (p *Profile) Rules() []RuleProfile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rules := make([]RuleProfile, 0, len(p.rules))
+	for _, rule := range p.rules {
+		rules = append(rules, *rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Cumulative != rules[j].Cumulative {
+			return rules[i].Cumulative > rules[j].Cumulative
+		}
+		return rules[i].Name < rules[j].Name
+	})
+	return rules
+}
+
+// WriteTable outputs a table with a line for each rule, sorted by decreasing
+// cumulative time, e.g.
+//
+//	RULE         ATTEMPTS  SUCCESSES  FAILURES  BACKTRACKED  SELF    CUM
+//	Json         1210      1006       204       388          1.2ms   9.8ms
+//	Array        1006      102        904       0            0.4ms   8.1ms
+//	...
+func (p *Profile) WriteTable(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RULE\tATTEMPTS\tSUCCESSES\tFAILURES\tBACKTRACKED\tSELF\tCUM")
+	for _, rule := range p.Rules() {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%s\n",
+			rule.Name, rule.Attempts, rule.Successes, rule.Failures, rule.Backtracked, rule.Self, rule.Cumulative)
+	}
+	return w.Flush()
+}
+
+// WritePprof outputs the profile in the gzipped protocol buffer format
+// understood by the pprof tool, e.g.
+//
+//	go tool pprof -http=: grammar.pprof
+//
+// The stack of each sample is the stack of rules being parsed, and the
+// samples have the following values: attempts, failures, backtracked tokens
+// and time (the default).
+func (p *Profile) WritePprof(out io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	strs := map[string]int64{}
+	var b protoBuffer
+	str := func(s string) int64 {
+		if i, ok := strs[s]; ok {
+			return i
+		}
+		i := int64(len(strs))
+		strs[s] = i
+		return i
+	}
+	str("")
+
+	// Message Profile, see
+	// https://github.com/google/pprof/blob/main/proto/profile.proto
+	for _, st := range profileSampleTypes {
+		var vt protoBuffer
+		vt.int64Field(1, str(st[0]))
+		vt.int64Field(2, str(st[1]))
+		b.messageField(1, vt)
+	}
+
+	// Each rule is a function, with a single location.
+	keys := make([]string, 0, len(p.samples))
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	ids := map[string]uint64{}
+	var names []string
+	for _, key := range keys {
+		sample := p.samples[key]
+		var locs []uint64
+		for _, name := range sample.stack {
+			id, ok := ids[name]
+			if !ok {
+				id = uint64(len(ids) + 1)
+				ids[name] = id
+				names = append(names, name)
+			}
+			locs = append(locs, id)
+		}
+		var sb protoBuffer
+		sb.packedUint64Field(1, locs)
+		sb.packedInt64Field(2, sample.values[:])
+		b.messageField(2, sb)
+	}
+	for i := range names {
+		id := uint64(i + 1)
+		var line, loc protoBuffer
+		line.uint64Field(1, id)
+		loc.uint64Field(1, id)
+		loc.messageField(4, line)
+		b.messageField(4, loc)
+	}
+	for i, name := range names {
+		var fn protoBuffer
+		fn.uint64Field(1, uint64(i+1))
+		fn.int64Field(2, str(name))
+		fn.int64Field(3, str(name))
+		b.messageField(5, fn)
+	}
+	b.int64Field(9, p.start.UnixNano())
+	b.int64Field(14, str(profileSampleTypes[profileTime][0]))
+
+	// The string table must be written in index order.
+	table := make([]string, len(strs))
+	for s, i := range strs {
+		table[i] = s
+	}
+	for _, s := range table {
+		b.stringField(6, s)
+	}
+
+	zw := gzip.NewWriter(out)
+	if _, err := zw.Write(b); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// protoBuffer is a minimal protocol buffer encoder, enough to write pprof
+// profiles.
+type protoBuffer []byte
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		*b = append(*b, byte(x)|0x80)
+		x >>= 7
+	}
+	*b = append(*b, byte(x))
+}
+
+func (b *protoBuffer) key(field int, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (b *protoBuffer) uint64Field(field int, x uint64) {
+	if x != 0 {
+		b.key(field, 0)
+		b.varint(x)
+	}
+}
+
+func (b *protoBuffer) int64Field(field int, x int64) {
+	b.uint64Field(field, uint64(x))
+}
+
+func (b *protoBuffer) bytesField(field int, data []byte) {
+	b.key(field, 2)
+	b.varint(uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+func (b *protoBuffer) stringField(field int, s string) {
+	b.bytesField(field, []byte(s))
+}
+
+func (b *protoBuffer) messageField(field int, m protoBuffer) {
+	b.bytesField(field, m)
+}
+
+func (b *protoBuffer) packedUint64Field(field int, xs []uint64) {
+	var p protoBuffer
+	for _, x := range xs {
+		p.varint(x)
+	}
+	b.bytesField(field, p)
+}
+
+func (b *protoBuffer) packedInt64Field(field int, xs []int64) {
+	var p protoBuffer
+	for _, x := range xs {
+		p.varint(uint64(x))
+	}
+	b.bytesField(field, p)
+}
@@ -0,0 +1,59 @@
+package grammar
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	prof := NewProfile()
+	for _, src := range []string{"1", "(a (1))"} {
+		stream, err := testTokenise(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expr testExpr
+		if err := Parse(&expr, stream, WithProfile(prof)); err != nil {
+			t.Fatalf("Error parsing %q: %s", src, err)
+		}
+	}
+	got := map[string]RuleProfile{}
+	for _, rule := range prof.Rules() {
+		rule.Self, rule.Cumulative = 0, 0
+		got[rule.Name] = rule
+	}
+	want := map[string]RuleProfile{
+		"testExpr":    {Name: "testExpr", Attempts: 7, Successes: 5, Failures: 2, Backtracked: 11},
+		"testList":    {Name: "testList", Attempts: 4, Successes: 2, Failures: 2},
+		"SimpleToken": {Name: "SimpleToken", Attempts: 12, Successes: 3, Failures: 9},
+		"Match":       {Name: "Match", Attempts: 6, Successes: 4, Failures: 2},
+	}
+	for name, w := range want {
+		if got[name] != w {
+			t.Errorf("Rule %s: got %+v, want %+v", name, got[name], w)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("Got %d rules, want %d", len(got), len(want))
+	}
+	var b bytes.Buffer
+	if err := prof.WritePprof(&b); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"attempts", "backtracked", "nanoseconds", "testExpr", "SimpleToken"} {
+		if !strings.Contains(string(data), s) {
+			t.Errorf("Missing %q in pprof output", s)
+		}
+	}
+}
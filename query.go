@@ -0,0 +1,390 @@
+package grammar
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A Query selects nodes in a parse tree, in the spirit of XPath.  A query is a
+// sequence of steps, each introduced by "/" (the step matches a child of the
+// node matched by the previous step) or "//" (the step matches any
+// descendant).  The first step is relative to the root of the tree, so "/Json"
+// matches the root if it is a Json rule, while "//Json" matches any Json rule
+// in the tree including the root.
+//
+// A step is a node test followed by any number of predicates.  Node tests are
+//
+//	Name        matches a node whose rule name (or Go type name for tokens) is Name
+//	*           matches any node
+//	Rule.Field  matches a node held in the field Field of a Rule parent
+//	.Field      matches a node held in the field Field of any parent
+//
+// Predicates are written in brackets after the test, all must hold for the
+// node to match:
+//
+//	[2]              the node is item 2 (counting from 0) of a repeated field
+//	[@type="str"]    the node is a token of type "str" (!= and ~ can be used
+//	                 instead of = to test for inequality or a regexp match)
+//	[@value="x"]     the node is a token with value "x" (same operators)
+//	[.Items[0]/Atom] the query relative to the node matches at least one node
+//
+// For example "/Json/Dict//DictItem.Key" finds the keys of all the items in a
+// top-level dictionary, and "//List[.Items[0]/.Atom[@value='define']]//.Atom"
+// finds all atoms inside a list whose first item is the atom "define".
+type Query struct {
+	src   string
+	steps []queryStep
+}
+
+// A QueryResult is a node found by a Query, together with its path.
+type QueryResult struct {
+	Node interface{}
+	Path Path
+}
+
+// CompileQuery parses a query, returning an error if it is not valid.
+func CompileQuery(src string) (*Query, error) {
+	p := queryParser{src: src}
+	steps, err := p.parseSteps(true)
+	if err == nil && p.pos < len(src) {
+		err = p.errorf("unexpected %q", src[p.pos:])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Query{src: src, steps: steps}, nil
+}
+
+// MustCompileQuery is like CompileQuery but panics if the query is invalid.
+func MustCompileQuery(src string) *Query {
+	q, err := CompileQuery(src)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// FindAll is a shorthand for compiling a query and finding its matches in a
+// tree.
+func FindAll(root interface{}, query string) ([]QueryResult, error) {
+	q, err := CompileQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return q.Find(root), nil
+}
+
+func (q *Query) String() string {
+	return q.src
+}
+
+// Find returns all the nodes in the tree rooted at root that match the query,
+// in the order Walk would visit them.
+func (q *Query) Find(root interface{}) []QueryResult {
+	var results []QueryResult
+	var chain []queryNode
+	WalkPrePost(root,
+		func(node interface{}, path Path) WalkAction {
+			chain = append(chain, newQueryNode(node, path))
+			if matchSteps(q.steps, len(q.steps)-1, chain, 0, len(chain)-1) {
+				results = append(results, QueryResult{
+					Node: node,
+					Path: append(Path(nil), path...),
+				})
+			}
+			return WalkContinue
+		},
+		func(node interface{}, path Path) WalkAction {
+			chain = chain[:len(chain)-1]
+			return WalkContinue
+		},
+	)
+	return results
+}
+
+// A queryNode is a node in the chain of ancestors of the node being matched.
+type queryNode struct {
+	node    interface{}
+	name    string
+	step    PathStep
+	hasStep bool
+}
+
+func newQueryNode(node interface{}, path Path) queryNode {
+	tp := reflect.TypeOf(node)
+	name := tp.Name()
+	if ruleDef, err := getRuleDef(tp); err == nil {
+		name = ruleDef.Name
+	}
+	qn := queryNode{node: node, name: name}
+	if len(path) > 0 {
+		qn.step = path[len(path)-1]
+		qn.hasStep = true
+	}
+	return qn
+}
+
+// matchSteps returns true if steps[0:k+1] match the chain of nodes chain[base:j+1]
+// with steps[k] matching chain[j].
+func matchSteps(steps []queryStep, k int, chain []queryNode, base, j int) bool {
+	step := steps[k]
+	if !step.match(chain[j]) {
+		return false
+	}
+	if k == 0 {
+		return step.descendant || j == base
+	}
+	if !step.descendant {
+		return j > base && matchSteps(steps, k-1, chain, base, j-1)
+	}
+	for i := j - 1; i >= base; i-- {
+		if matchSteps(steps, k-1, chain, base, i) {
+			return true
+		}
+	}
+	return false
+}
+
+type queryStep struct {
+	descendant bool   // True if the step is introduced by "//"
+	name       string // Required rule name, empty for any
+	parent     string // Required parent rule name, empty for any
+	field      string // Required parent field, empty for any
+	predicates []queryPredicate
+}
+
+func (s queryStep) match(n queryNode) bool {
+	if s.name != "" && s.name != n.name {
+		return false
+	}
+	if s.field != "" && (!n.hasStep || s.field != n.step.Field) {
+		return false
+	}
+	if s.parent != "" && (!n.hasStep || s.parent != n.step.Rule) {
+		return false
+	}
+	for _, pred := range s.predicates {
+		if !pred.match(n) {
+			return false
+		}
+	}
+	return true
+}
+
+type queryPredicate interface {
+	match(queryNode) bool
+}
+
+// An indexPredicate matches an item at a given index in a repeated field.
+type indexPredicate int
+
+func (p indexPredicate) match(n queryNode) bool {
+	return n.hasStep && n.step.Index == int(p)
+}
+
+// A tokenPredicate matches a token according to its type or value.
+type tokenPredicate struct {
+	attr    string // "type" or "value"
+	op      string // "=", "!=" or "~"
+	operand string
+	re      *regexp.Regexp
+}
+
+func (p tokenPredicate) match(n queryNode) bool {
+	tok, ok := n.node.(Token)
+	if !ok {
+		return false
+	}
+	v := tok.Value()
+	if p.attr == "type" {
+		v = tok.Type()
+	}
+	switch p.op {
+	case "=":
+		return v == p.operand
+	case "!=":
+		return v != p.operand
+	default:
+		return p.re.MatchString(v)
+	}
+}
+
+// A subqueryPredicate matches a node if the relative query matches at least
+// one node in the tree rooted at it.
+type subqueryPredicate []queryStep
+
+func (p subqueryPredicate) match(n queryNode) bool {
+	found := false
+	chain := []queryNode{n}
+	forEachChild(reflect.ValueOf(n.node), func(child reflect.Value, step PathStep) bool {
+		found = p.matchDescendants(chain, child, step)
+		return !found
+	})
+	return found
+}
+
+func (p subqueryPredicate) matchDescendants(chain []queryNode, v reflect.Value, step PathStep) bool {
+	node := v.Interface()
+	chain = append(chain, newQueryNode(node, Path{step}))
+	if matchSteps(p, len(p)-1, chain, 1, len(chain)-1) {
+		return true
+	}
+	return !forEachChild(v, func(child reflect.Value, step PathStep) bool {
+		return !p.matchDescendants(chain, child, step)
+	})
+}
+
+type queryParser struct {
+	src string
+	pos int
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid query %q at offset %d: %s", p.src, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *queryParser) consume(s string) bool {
+	if strings.HasPrefix(p.src[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+// parseSteps parses a sequence of steps.  If absolute is false, the first step
+// does not need to be introduced by "/" or "//".
+func (p *queryParser) parseSteps(absolute bool) ([]queryStep, error) {
+	var steps []queryStep
+	for {
+		var step queryStep
+		switch {
+		case p.consume("//"):
+			step.descendant = true
+		case p.consume("/"):
+		case len(steps) == 0 && !absolute:
+		case len(steps) == 0:
+			return nil, p.errorf("query must start with / or //")
+		default:
+			return steps, nil
+		}
+		if err := p.parseTest(&step); err != nil {
+			return nil, err
+		}
+		for p.consume("[") {
+			pred, err := p.parsePredicate()
+			if err != nil {
+				return nil, err
+			}
+			if !p.consume("]") {
+				return nil, p.errorf("expected ]")
+			}
+			step.predicates = append(step.predicates, pred)
+		}
+		steps = append(steps, step)
+	}
+}
+
+func (p *queryParser) parseTest(step *queryStep) error {
+	if p.consume("*") {
+		return nil
+	}
+	name := p.parseIdent()
+	if p.consume(".") {
+		if step.field = p.parseIdent(); step.field == "" {
+			return p.errorf("expected field name")
+		}
+		step.parent = name
+	} else if step.name = name; name == "" {
+		return p.errorf("expected *, rule name or field")
+	}
+	return nil
+}
+
+func (p *queryParser) parseIdent() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || p.pos > start && '0' <= c && c <= '9' {
+			p.pos++
+		} else {
+			break
+		}
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *queryParser) parsePredicate() (queryPredicate, error) {
+	start := p.pos
+	for p.pos < len(p.src) && '0' <= p.src[p.pos] && p.src[p.pos] <= '9' {
+		p.pos++
+	}
+	if p.pos > start {
+		n, err := strconv.Atoi(p.src[start:p.pos])
+		if err != nil {
+			return nil, p.errorf("invalid index: %s", err)
+		}
+		return indexPredicate(n), nil
+	}
+	if p.consume("@") {
+		return p.parseTokenPredicate()
+	}
+	steps, err := p.parseSteps(false)
+	if err != nil {
+		return nil, err
+	}
+	return subqueryPredicate(steps), nil
+}
+
+func (p *queryParser) parseTokenPredicate() (queryPredicate, error) {
+	pred := tokenPredicate{attr: p.parseIdent()}
+	if pred.attr != "type" && pred.attr != "value" {
+		return nil, p.errorf("expected @type or @value")
+	}
+	switch {
+	case p.consume("!="):
+		pred.op = "!="
+	case p.consume("="):
+		pred.op = "="
+	case p.consume("~"):
+		pred.op = "~"
+	default:
+		return nil, p.errorf("expected =, != or ~")
+	}
+	operand, err := p.parseString()
+	if err != nil {
+		return nil, err
+	}
+	pred.operand = operand
+	if pred.op == "~" {
+		if pred.re, err = regexp.Compile(operand); err != nil {
+			return nil, p.errorf("invalid regexp: %s", err)
+		}
+	}
+	return pred, nil
+}
+
+// parseString parses a string delimited by double or single quotes.  Inside
+// the string a backslash escapes the next character.
+func (p *queryParser) parseString() (string, error) {
+	if p.pos >= len(p.src) || p.src[p.pos] != '"' && p.src[p.pos] != '\'' {
+		return "", p.errorf("expected quoted string")
+	}
+	quote := p.src[p.pos]
+	var b strings.Builder
+	for i := p.pos + 1; i < len(p.src); i++ {
+		c := p.src[i]
+		switch {
+		case c == quote:
+			p.pos = i + 1
+			return b.String(), nil
+		case c == '\\' && i+1 < len(p.src):
+			i++
+			c = p.src[i]
+		}
+		b.WriteByte(c)
+	}
+	return "", p.errorf("unterminated string")
+}
@@ -0,0 +1,86 @@
+package grammar
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	expr := mustParseTestExpr(t, "(a (define x (f 1) 2) (b y) (define z))")
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{
+			query: "/testExpr/.List/.Items/.Atom",
+			want:  []string{"List.Items[0].Atom"},
+		},
+		{
+			query: "//testList.Items[1]/.Atom",
+			want: []string{
+				"List.Items[1].List.Items[1].Atom",
+				"List.Items[2].List.Items[1].Atom",
+				"List.Items[3].List.Items[1].Atom",
+			},
+		},
+		{
+			query: "//testList[.Items[0]/.Atom[@value='define']]//.Atom",
+			want: []string{
+				"List.Items[1].List.Items[0].Atom",
+				"List.Items[1].List.Items[1].Atom",
+				"List.Items[1].List.Items[2].List.Items[0].Atom",
+				"List.Items[3].List.Items[0].Atom",
+				"List.Items[3].List.Items[1].Atom",
+			},
+		},
+		{
+			query: `//*[@type="number"]`,
+			want: []string{
+				"List.Items[1].List.Items[2].List.Items[1].Number",
+				"List.Items[1].List.Items[3].Number",
+			},
+		},
+		{
+			query: "//.Atom[@value~'^[xyz]$'][@value!='y']",
+			want: []string{
+				"List.Items[1].List.Items[1].Atom",
+				"List.Items[3].List.Items[1].Atom",
+			},
+		},
+		{
+			query: "/testList",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			results, err := FindAll(expr, tt.query)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			var got []string
+			for _, res := range results {
+				got = append(got, res.Path.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileQueryErrors(t *testing.T) {
+	for _, query := range []string{
+		"",
+		"testList",
+		"/testList[",
+		"/testList[@name='x']",
+		"/testList[@value='x]",
+		"/testList[@value~'(']",
+		"/.",
+		"/a b",
+	} {
+		if _, err := CompileQuery(query); err == nil {
+			t.Errorf("Expected error compiling %q", query)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package grammar
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 )
@@ -22,6 +23,25 @@ func (Empty) Parse(r interface{}, s *ParserState, opts TokenOptions) *ParseError
 	return nil
 }
 
+// Commit can be used as a field in a Seq rule to stop backtracking, like a cut
+// in PEG grammars.  Once all the fields before the Commit field have matched,
+// the parser is committed to this rule: if a field after it fails to match,
+// the error is final and no other alternatives are tried in enclosing rules
+// (except in lookahead fields).  This makes parsing faster and error messages
+// more precise.  A Commit field cannot be the last field of a rule.
+type Commit struct{}
+
+var commitType = reflect.TypeOf(Commit{})
+
+// A Validator is a rule with extra checks which cannot be expressed in the
+// grammar, e.g. that a number does not overflow or that a closing tag matches
+// the opening one.  The Validate method is called after the rule has matched,
+// with the fields set.  If it returns an error, the rule fails at the position
+// where it started, so the parser backtracks and tries other alternatives.
+type Validator interface {
+	Validate(s *ParserState) error
+}
+
 // OneOf should be used as the first field of a Rule struct to signify that it
 // should match exactly one of the fields
 type OneOf struct{}
@@ -29,55 +49,27 @@ type OneOf struct{}
 var _ Parser = OneOf{}
 
 func (OneOf) Parse(r interface{}, s *ParserState, opts TokenOptions) *ParseError {
-	ruleDef, elem := getRuleDefAndValue(r)
-	var err, fieldErr *ParseError
-	ruleDef.DropOptions.DropMatchingNextTokens(s)
-	for _, ruleField := range ruleDef.Fields {
-		switch {
-		case ruleField.Pointer:
-			{
-				start := s.Save()
-				fieldPtrV := reflect.New(ruleField.BaseType)
-				fieldErr = ParseWithOptions(fieldPtrV.Interface(), s, ruleField.TokenOptions)
-				if fieldErr == nil {
-					elem.Field(ruleField.Index).Set(fieldPtrV)
-					return nil
-				}
-				s.Restore(start)
-				err = err.Merge(fieldErr)
-			}
-		case ruleField.Array:
-			{
-				itemsV := reflect.Zero(reflect.SliceOf(ruleField.BaseType))
-				var sz int
-				arrStart := s.Save()
-				for sz = 0; ruleField.Max == 0 || sz < ruleField.Max; sz++ {
-					start := s.Save()
-					itemPtrV := reflect.New(ruleField.BaseType)
-					fieldErr = ParseWithOptions(itemPtrV.Interface(), s, ruleField.TokenOptions)
-					if fieldErr != nil {
-						if sz < ruleField.Min {
-							s.Restore(arrStart)
-							break
-						}
-						s.Restore(start)
-						if sz > 0 {
-							elem.Field(ruleField.Index).Set(itemsV)
-							return nil
-						}
-						err = err.Merge(fieldErr)
-						break
-					}
-					itemsV = reflect.Append(itemsV, itemPtrV.Elem())
-				}
-			}
-		default:
-			panic("should not get here")
-		}
-	}
-	return err
+	return newRuleFrame(r).run(s)
+}
+
+// Longest can be used instead of OneOf as the first field of a Rule struct.
+// Rather than keeping the first field which matches, all the fields are tried
+// and the one which consumes the most tokens is kept.  If several fields match
+// the same number of tokens, the first one is kept, unless the tag
+// ties:"error" is given in which case the rule fails with an error wrapping
+// ErrAmbiguous.
+type Longest struct{}
+
+var _ Parser = Longest{}
+
+func (Longest) Parse(r interface{}, s *ParserState, opts TokenOptions) *ParseError {
+	return newRuleFrame(r).run(s)
 }
 
+// ErrAmbiguous is wrapped by the error returned by a Longest rule with the
+// ties:"error" tag when two alternatives match the same tokens.
+var ErrAmbiguous = errors.New("ambiguous match")
+
 // Seq should be used as the first field of a Rule struct to signify that it
 // should match all the fields in sequence.
 type Seq struct{}
@@ -85,75 +77,651 @@ type Seq struct{}
 var _ Parser = Seq{}
 
 func (Seq) Parse(r interface{}, s *ParserState, opts TokenOptions) *ParseError {
+	return newRuleFrame(r).run(s)
+}
+
+// A ruleFrame holds the state of parsing a Seq or OneOf rule.  It is a state
+// machine which asks for the values of fields to be parsed one at a time, so
+// that it can be driven either by recursive calls (see run) or from an explicit
+// stack (see WithIterativeEngine).
+type ruleFrame struct {
+	ruleDef    *RuleDef
+	elem       reflect.Value
+	state      frameState
+	ruleStart  savePoint     // Position before parsing the rule
+	startErr   *ParseError   // The furthest error before parsing the rule
+	i          int           // Position of the current field
+	child      reflect.Value // Pointer to the field value or item being parsed
+	childField RuleField     // The field the child is parsed for
+	childIndex int           // The position of the child in a repeated field, or -1
+	items      reflect.Value // Items parsed so far for a repeated field
+	start      savePoint     // Position before parsing the child
+	arrStart   savePoint     // Position before parsing the first item
+	sepStart   savePoint     // Position before parsing the separator
+	sepPos     savePoint     // Position before the separator preceding the item, or -1
+	err        *ParseError   // Errors of the fields merged so far
+	lastErr    *ParseError   // The furthest error before a negative lookahead
+	committed  bool          // True if a Commit field has been reached in a Seq
+	itemCount  int           // Number of fields and items matched in a Seq
+	choice     *choiceState  // Alternatives matched so far, if they are all tried
+}
+
+// choiceState keeps track of the alternatives which matched when all the
+// fields of a OneOf rule are tried, i.e. in a Longest rule or when auditing
+// ambiguities.  After each alternative the token stream goes back to the
+// start, so the value of the best alternative and the changes it made to the
+// user state are kept until all the alternatives have been tried.
+type choiceState struct {
+	altStart    savePoint     // Position before parsing the alternatives
+	best        int           // Position of the best field, or -1
+	bestEnd     int           // Position after the best field
+	bestValue   reflect.Value // Value of the best field
+	bestState   interface{}   // User state after the best field
+	bestJournal []stateChange // Changes to the user state made by the best field
+	tie         int           // Position of a field as long as the best one, or -1
+	lastErr     *ParseError   // Furthest error when the best field matched
+	matches     []AmbiguousMatch
+}
+
+type frameState uint8
+
+const (
+	frameInit       frameState = iota // Nothing has been parsed yet
+	frameStartField                   // Field i should be parsed next
+	frameStartItem                    // The next item of field i should be parsed
+	frameChildDone                    // The child has been parsed
+	frameStartSep                     // A separator of field i should be parsed next
+	frameSepDone                      // The child separator has been parsed
+	frameEndItems                     // All the items of field i have been parsed
+)
+
+func newRuleFrame(r interface{}) *ruleFrame {
 	ruleDef, elem := getRuleDefAndValue(r)
-	var err, fieldErr *ParseError
-	itemCount := 0
-	var fieldPtrV reflect.Value
-	dropOptions := ruleDef.DropOptions
-	for _, ruleField := range ruleDef.Fields {
-		if s.Debug() {
-			s.Logf("  .%s tok #%d", ruleField.Name, s.Save())
+	return &ruleFrame{ruleDef: ruleDef, elem: elem}
+}
+
+// run parses the rule, parsing the children by recursive calls.
+func (f *ruleFrame) run(s *ParserState) *ParseError {
+	var childErr *ParseError
+	for {
+		more, err := f.next(s, childErr)
+		if !more {
+			return err
 		}
-		dropOptions.DropMatchingNextTokens(s)
-		switch {
-		case ruleField.Pointer:
-			{
-				start := s.Save()
-				fieldPtrV = reflect.New(ruleField.BaseType)
-				fieldErr = ParseWithOptions(fieldPtrV.Interface(), s, ruleField.TokenOptions)
-				if fieldErr != nil {
-					err = err.Merge(fieldErr)
-					s.Restore(start)
-				} else {
-					elem.Field(ruleField.Index).Set(fieldPtrV)
-					itemCount++
+		childErr = parseField(f.child.Interface(), s, f.ruleDef, f.childField, f.childIndex)
+	}
+}
+
+// next advances the parsing of the rule until either the child needs to be
+// parsed, in which case it returns true, or the rule has been parsed, in which
+// case it returns false and an error if the rule did not match.  After next
+// returns true, it must be called again with the result of parsing the child.
+func (f *ruleFrame) next(s *ParserState, childErr *ParseError) (bool, *ParseError) {
+	if f.state == frameInit {
+		f.ruleStart = s.savePointAt(s.TokenStream.Save())
+		f.startErr = s.lastErr
+	}
+	var more bool
+	var err *ParseError
+	if f.ruleDef.OneOf {
+		more, err = f.nextOneOf(s, childErr)
+	} else {
+		more, err = f.nextSeq(s, childErr)
+		if err != nil && f.committed && !err.Committed {
+			committedErr := *err
+			committedErr.Committed = true
+			err = &committedErr
+		}
+	}
+	if !more && err == nil {
+		err = f.validate(s)
+	}
+	return more, err
+}
+
+// validate calls the Validate method of the rule if it has one, after the rule
+// has matched.  A validation error is not committed, as the rule did match.
+func (f *ruleFrame) validate(s *ParserState) *ParseError {
+	v, ok := f.elem.Addr().Interface().(Validator)
+	if !ok {
+		return nil
+	}
+	if err := v.Validate(s); err != nil {
+		return f.failAtStart(s, err)
+	}
+	return nil
+}
+
+// failAtStart makes a rule which matched fail at its start position.  Errors
+// recorded while parsing the rule are discarded as they do not explain why it
+// failed.
+func (f *ruleFrame) failAtStart(s *ParserState, err error) *ParseError {
+	s.restoreSavePoint(f.ruleStart)
+	tok := s.Next()
+	s.restoreSavePoint(f.ruleStart)
+	s.lastErr = f.startErr
+	return s.MergeError(&ParseError{
+		Token: tok,
+		Err:   err,
+		Pos:   f.ruleStart.pos,
+	})
+}
+
+// committedFailure returns true if the child failed with a committed error,
+// which must be returned by the rule straight away.
+func (f *ruleFrame) committedFailure(s *ParserState, ruleField RuleField, childErr *ParseError) bool {
+	if childErr == nil || !childErr.Committed || ruleField.Lookahead != NoLookahead || f.exploring() {
+		return false
+	}
+	s.coverField(f.ruleDef, f.i, false, -1)
+	return true
+}
+
+// field returns the field currently being parsed.
+func (f *ruleFrame) field() RuleField {
+	return f.ruleDef.Fields[f.i]
+}
+
+// requestChild asks for a value of the field to be parsed.  The index is the
+// position of the value in a repeated field, or -1.
+func (f *ruleFrame) requestChild(ruleField RuleField, index int) (bool, *ParseError) {
+	f.child = reflect.New(ruleField.BaseType)
+	f.childField = ruleField
+	f.childIndex = index
+	f.state = frameChildDone
+	return true, nil
+}
+
+func (f *ruleFrame) traceField(s *ParserState, ruleField RuleField) {
+	if s.tracer != nil {
+		s.trace(TraceEvent{Kind: TraceField, Rule: f.ruleDef.Name, Field: ruleField.Name, Pos: s.TokenStream.Save()})
+	}
+}
+
+func (f *ruleFrame) nextOneOf(s *ParserState, childErr *ParseError) (bool, *ParseError) {
+	ruleDef := f.ruleDef
+	for {
+		switch f.state {
+		case frameInit:
+			ruleDef.DropOptions.DropMatchingNextTokens(s)
+			if ruleDef.Longest || s.audit != nil {
+				f.choice = &choiceState{
+					altStart: s.savePointAt(s.TokenStream.Save()),
+					best:     -1,
+					tie:      -1,
 				}
 			}
-		case ruleField.Array:
-			{
-				itemsV := reflect.Zero(reflect.SliceOf(ruleField.BaseType))
-				var sz int
-				for sz = 0; ruleField.Max == 0 || sz < ruleField.Max; sz++ {
-					start := s.Save()
-					fieldPtrV = reflect.New(ruleField.BaseType)
-					fieldErr = ParseWithOptions(fieldPtrV.Interface(), s, ruleField.TokenOptions)
-					if fieldErr != nil {
-						err = err.Merge(fieldErr)
-						if sz < ruleField.Min {
-							return err
-						}
-						s.Restore(start)
-						break
-					}
-					itemsV = reflect.Append(itemsV, fieldPtrV.Elem())
-					itemCount++
-					start = s.Save()
-					_, err := ruleField.SepOptions.MatchNextToken(s)
-					if err != nil {
-						s.Restore(start)
-						break
-					}
+			f.state = frameStartField
+		case frameStartField:
+			if f.i == len(ruleDef.Fields) {
+				if f.choice != nil && f.choice.best >= 0 {
+					return false, f.endChoice(s)
 				}
-				elem.Field(ruleField.Index).Set(itemsV)
+				return false, f.err
+			}
+			ruleField := f.field()
+			f.traceField(s, ruleField)
+			switch {
+			case ruleField.Pointer:
+				f.start = s.savePointAt(s.Save())
+				return f.requestChild(ruleField, -1)
+			case ruleField.Array:
+				f.startItems(s, ruleField)
+			default:
+				panic("should not get here")
+			}
+		case frameStartItem:
+			if more, err := f.nextItem(s); more {
+				return more, err
+			}
+		case frameStartSep:
+			if more, err := f.nextSep(s); more {
+				return more, err
 			}
-		default:
-			fieldPtrV = reflect.New(ruleField.BaseType)
-			fieldErr = ParseWithOptions(fieldPtrV.Interface(), s, ruleField.TokenOptions)
-			if fieldErr != nil {
-				return err.Merge(fieldErr)
+		case frameSepDone:
+			if f.committedFailure(s, f.childField, childErr) {
+				return false, childErr
 			}
-			elem.Field(ruleField.Index).Set(fieldPtrV.Elem())
-			itemCount++
+			f.sepDone(s, childErr)
+		case frameEndItems:
+			ruleField := f.field()
+			sz := f.items.Len()
+			if sz > 0 && sz >= ruleField.Min {
+				s.coverField(ruleDef, f.i, true, sz)
+				if f.choice != nil {
+					f.alternativeDone(s, f.items)
+					continue
+				}
+				f.elem.Field(ruleField.Index).Set(f.items)
+				return false, nil
+			}
+			s.restoreSavePoint(f.arrStart)
+			if sz < ruleField.Min {
+				s.coverField(ruleDef, f.i, false, -1)
+			} else {
+				s.coverField(ruleDef, f.i, false, 0)
+			}
+			f.i++
+			f.state = frameStartField
+		case frameChildDone:
+			ruleField := f.field()
+			if f.committedFailure(s, ruleField, childErr) {
+				return false, childErr
+			}
+			if ruleField.Array {
+				f.itemDone(s, ruleField, childErr)
+				continue
+			}
+			s.coverField(ruleDef, f.i, childErr == nil, -1)
+			if childErr == nil {
+				if f.choice != nil {
+					f.alternativeDone(s, f.child)
+					continue
+				}
+				f.elem.Field(ruleField.Index).Set(f.child)
+				return false, nil
+			}
+			s.restoreSavePoint(f.start)
+			f.err = f.err.Merge(childErr)
+			f.i++
+			f.state = frameStartField
 		}
 	}
-	if itemCount == 0 {
-		pos := s.Save()
-		tok := s.Next()
-		return &ParseError{
-			Token: tok,
-			Err:   fmt.Errorf("empty match for rule %s", ruleDef.Name),
-			Pos:   pos,
-		}
+}
+
+// alternativeDone is called when field i has matched in a OneOf rule whose
+// fields are all tried.  It records the match and goes back to try the next
+// field.
+func (f *ruleFrame) alternativeDone(s *ParserState, value reflect.Value) {
+	c := f.choice
+	end := s.TokenStream.Save()
+	if s.audit != nil {
+		c.matches = append(c.matches, AmbiguousMatch{
+			Field: f.field().Name,
+			Span:  Span{Start: c.altStart.pos, End: end},
+		})
+	}
+	switch {
+	case c.best < 0 || f.ruleDef.Longest && end > c.bestEnd:
+		c.best, c.bestEnd, c.tie = f.i, end, -1
+		c.bestValue = value
+		c.bestState = s.userState
+		c.bestJournal = append([]stateChange(nil), s.stateJournal[c.altStart.journal:]...)
+		c.lastErr = s.lastErr
+	case end == c.bestEnd && c.tie < 0:
+		c.tie = f.i
+	}
+	s.restoreSavePoint(c.altStart)
+	f.i++
+	f.state = frameStartField
+}
+
+// exploring returns true if a OneOf rule is trying the fields after the one
+// which matched in order to audit ambiguities.
+func (f *ruleFrame) exploring() bool {
+	return f.choice != nil && !f.ruleDef.Longest && f.choice.best >= 0
+}
+
+// endChoice is called when all the fields of a OneOf rule have been tried and
+// at least one matched.  It records ambiguities and sets the best field,
+// unless there is a tie which is not allowed.
+func (f *ruleFrame) endChoice(s *ParserState) *ParseError {
+	c := f.choice
+	if len(c.matches) > 1 {
+		s.audit.record(f.ruleDef.Name, f.ruleDef.Fields[c.best].Name, c.matches)
+	}
+	if c.tie >= 0 && f.ruleDef.TieError {
+		return f.failAtStart(s, fmt.Errorf("%w: %s and %s both match %d tokens", ErrAmbiguous,
+			f.ruleDef.Fields[c.best].Name, f.ruleDef.Fields[c.tie].Name, c.bestEnd-c.altStart.pos))
+	}
+	if !f.ruleDef.Longest {
+		// Trying more fields must not change the result.
+		s.lastErr = c.lastErr
+	}
+	s.Restore(c.bestEnd)
+	s.userState = c.bestState
+	s.stateJournal = append(s.stateJournal[:c.altStart.journal], c.bestJournal...)
+	f.i = c.best
+	ruleField := f.field()
+	if ruleField.Array {
+		f.items = c.bestValue
+		f.elem.Field(ruleField.Index).Set(f.items)
+	} else {
+		f.elem.Field(ruleField.Index).Set(c.bestValue)
 	}
 	return nil
 }
+
+func (f *ruleFrame) nextSeq(s *ParserState, childErr *ParseError) (bool, *ParseError) {
+	ruleDef := f.ruleDef
+	for {
+		switch f.state {
+		case frameInit, frameStartField:
+			if f.i == len(ruleDef.Fields) {
+				return false, f.emptyMatchError(s)
+			}
+			ruleField := f.field()
+			if ruleField.Commit {
+				f.committed = true
+			}
+			f.traceField(s, ruleField)
+			ruleDef.DropOptions.DropMatchingNextTokens(s)
+			switch {
+			case ruleField.Pointer:
+				f.start = s.savePointAt(s.Save())
+				return f.requestChild(ruleField, -1)
+			case ruleField.Array:
+				f.startItems(s, ruleField)
+			default:
+				if ruleField.Lookahead != NoLookahead {
+					f.start = s.savePointAt(s.Save())
+					f.lastErr = s.lastErr
+				}
+				return f.requestChild(ruleField, -1)
+			}
+		case frameStartItem:
+			if more, err := f.nextItem(s); more {
+				return more, err
+			}
+		case frameStartSep:
+			if more, err := f.nextSep(s); more {
+				return more, err
+			}
+		case frameSepDone:
+			if f.committedFailure(s, f.childField, childErr) {
+				return false, childErr
+			}
+			f.sepDone(s, childErr)
+		case frameEndItems:
+			ruleField := f.field()
+			sz := f.items.Len()
+			if sz < ruleField.Min {
+				s.coverField(ruleDef, f.i, false, -1)
+				return false, f.err.Merge(f.tooFewItemsError(s))
+			}
+			s.coverField(ruleDef, f.i, sz > 0, sz)
+			f.elem.Field(ruleField.Index).Set(f.items)
+			f.itemCount += sz
+			f.i++
+			f.state = frameStartField
+		case frameChildDone:
+			ruleField := f.field()
+			if f.committedFailure(s, ruleField, childErr) {
+				return false, childErr
+			}
+			switch {
+			case ruleField.Pointer:
+				s.coverField(ruleDef, f.i, childErr == nil, -1)
+				if childErr != nil {
+					f.err = f.err.Merge(childErr)
+					s.restoreSavePoint(f.start)
+				} else {
+					f.elem.Field(ruleField.Index).Set(f.child)
+					f.itemCount++
+				}
+				f.i++
+				f.state = frameStartField
+			case ruleField.Array:
+				f.itemDone(s, ruleField, childErr)
+			case ruleField.Lookahead != NoLookahead:
+				if err := f.lookaheadDone(s, ruleField, childErr); err != nil {
+					return false, f.err.Merge(err)
+				}
+				f.i++
+				f.state = frameStartField
+			default:
+				s.coverField(ruleDef, f.i, childErr == nil, -1)
+				if childErr != nil {
+					return false, f.err.Merge(childErr)
+				}
+				f.elem.Field(ruleField.Index).Set(f.child.Elem())
+				f.itemCount++
+				f.i++
+				f.state = frameStartField
+			}
+		}
+	}
+}
+
+// Repeated fields are parsed in the same way in Seq and OneOf rules: the
+// frame moves from frameStartItem to frameChildDone for each item, then to
+// frameEndItems when there are no more items.  Separators are parsed between
+// items (frameStartSep then frameSepDone) according to the leading and
+// trailing policies of the field.
+
+// startItems starts parsing the items of a repeated field, with a leading
+// separator if there can be one.
+func (f *ruleFrame) startItems(s *ParserState, ruleField RuleField) {
+	f.items = reflect.Zero(reflect.SliceOf(ruleField.BaseType))
+	f.arrStart = s.savePointAt(s.Save())
+	f.sepPos = savePoint{pos: -1}
+	if ruleField.Leading == SepForbid {
+		f.state = frameStartItem
+	} else {
+		f.state = frameStartSep
+	}
+}
+
+// sepRuleField returns the field used to parse the separators of a repeated
+// field, if they are rules.  As the separators are not kept in the tree, the
+// path of their spans (see WithSpans) is made from the names of the field and
+// the rule, e.g. "Stmts@StmtSep[1]".
+func sepRuleField(ruleField RuleField) (RuleField, bool) {
+	if ruleField.SepRule == nil {
+		return RuleField{}, false
+	}
+	return RuleField{
+		FieldType: FieldType{BaseType: ruleField.SepRule},
+		Name:      ruleField.Name + "@" + ruleTypeName(ruleField.SepRule),
+		Index:     ruleField.Index,
+	}, true
+}
+
+// nextItem requests the next item of a repeated field to be parsed, unless
+// the maximum number of items has been reached.
+func (f *ruleFrame) nextItem(s *ParserState) (bool, *ParseError) {
+	ruleField := f.field()
+	if ruleField.Max != 0 && f.items.Len() >= ruleField.Max {
+		f.state = frameEndItems
+		return false, nil
+	}
+	f.start = s.savePointAt(s.Save())
+	return f.requestChild(ruleField, f.items.Len())
+}
+
+// itemDone is called when an item of a repeated field has been parsed.  If it
+// matched, the separator that follows is parsed if there is one.
+func (f *ruleFrame) itemDone(s *ParserState, ruleField RuleField, childErr *ParseError) {
+	f.state = frameEndItems
+	if childErr != nil {
+		if f.sepPos.pos >= 0 && (ruleField.Trailing == SepForbid || f.items.Len() == 0) {
+			// The separator is not followed by an item.
+			f.err = f.err.Merge(f.danglingSeparator(s, ruleField, childErr))
+			s.restoreSavePoint(f.sepPos)
+		} else {
+			f.err = f.err.Merge(childErr)
+			s.restoreSavePoint(f.start)
+		}
+		return
+	}
+	f.items = reflect.Append(f.items, f.child.Elem())
+	f.sepPos = savePoint{pos: -1}
+	if s.TokenStream.Save() == f.start.pos {
+		// The item matched no tokens so repeating it would never end.
+		return
+	}
+	if ruleField.hasSep() {
+		f.state = frameStartSep
+	} else {
+		f.state = frameStartItem
+	}
+}
+
+// nextSep parses a separator.  If separators are rules, the separator is
+// requested as a child.
+func (f *ruleFrame) nextSep(s *ParserState) (bool, *ParseError) {
+	ruleField := f.field()
+	f.sepStart = s.savePointAt(s.Save())
+	if sepField, ok := sepRuleField(ruleField); ok {
+		more, err := f.requestChild(sepField, f.items.Len())
+		f.state = frameSepDone
+		return more, err
+	}
+	_, err := ruleField.SepOptions.MatchNextToken(s)
+	f.sepDone(s, err)
+	return false, nil
+}
+
+// sepDone is called when a separator has been parsed.  It decides whether
+// another item should be parsed.
+func (f *ruleFrame) sepDone(s *ParserState, sepErr *ParseError) {
+	ruleField := f.field()
+	leading := f.items.Len() == 0
+	f.state = frameEndItems
+	if sepErr != nil {
+		s.restoreSavePoint(f.sepStart)
+		switch {
+		case leading && ruleField.Leading == SepRequire:
+			f.err = f.err.Merge(sepErr)
+		case leading:
+			f.state = frameStartItem
+		case ruleField.Trailing == SepRequire:
+			// The last item must be followed by a separator.
+			f.err = f.err.Merge(sepErr)
+			f.items = f.items.Slice(0, f.items.Len()-1)
+			s.restoreSavePoint(f.start)
+		}
+		return
+	}
+	if !leading && ruleField.Max != 0 && f.items.Len() >= ruleField.Max {
+		if ruleField.Trailing == SepForbid {
+			s.restoreSavePoint(f.sepStart)
+		}
+		return
+	}
+	f.sepPos = f.sepStart
+	f.state = frameStartItem
+}
+
+// danglingSeparator returns the error for an item which failed to parse after
+// a separator.  If the item failed on its first token, a more precise error
+// replaces the furthest error.
+func (f *ruleFrame) danglingSeparator(s *ParserState, ruleField RuleField, childErr *ParseError) *ParseError {
+	if childErr.Pos != f.start.pos || s.lastErr == nil || s.lastErr.Pos != f.start.pos {
+		return childErr
+	}
+	err := &ParseError{
+		Token: s.lastErr.Token,
+		Err:   fmt.Errorf("%w, expected %s", ErrDanglingSeparator, fieldDescription(ruleField)),
+		Pos:   f.start.pos,
+	}
+	s.lastErr = nil
+	return s.MergeError(err)
+}
+
+// tooFewItemsError returns an error if there are not enough items in a
+// repeated field of a Seq and no other error explains why.
+func (f *ruleFrame) tooFewItemsError(s *ParserState) *ParseError {
+	if f.err != nil {
+		return nil
+	}
+	pos := s.Save()
+	tok := s.Next()
+	s.Restore(pos)
+	return &ParseError{
+		Token: tok,
+		Err:   fmt.Errorf("expected at least %d %s", f.field().Min, fieldDescription(f.field())),
+		Pos:   pos,
+	}
+}
+
+// lookaheadDone restores the token stream after a lookahead field has been
+// parsed and returns an error if the lookahead failed.
+func (f *ruleFrame) lookaheadDone(s *ParserState, ruleField RuleField, childErr *ParseError) *ParseError {
+	s.restoreSavePoint(f.start)
+	if childErr != nil && childErr.Committed {
+		// Lookahead fields only test whether the field matches.
+		uncommittedErr := *childErr
+		uncommittedErr.Committed = false
+		childErr = &uncommittedErr
+	}
+	ok := (childErr == nil) == (ruleField.Lookahead == AndLookahead)
+	s.coverField(f.ruleDef, f.i, ok, -1)
+	if ruleField.Lookahead == AndLookahead {
+		return childErr
+	}
+	// Errors inside a negative lookahead are not relevant to the user.
+	s.lastErr = f.lastErr
+	if ok {
+		return nil
+	}
+	tok := s.Next()
+	s.restoreSavePoint(f.start)
+	return &ParseError{
+		Token: tok,
+		Err:   fmt.Errorf("unexpected %s", fieldDescription(ruleField)),
+		Pos:   f.start.pos,
+	}
+}
+
+// fieldDescription describes what a field matches for error messages.
+func fieldDescription(ruleField RuleField) string {
+	if len(ruleField.TokenParseOptions) > 0 {
+		return tokenOptionsLabel(ruleField.TokenOptions)
+	}
+	if ruleDef, err := getRuleDef(ruleField.BaseType); err == nil {
+		return ruleDef.Name
+	}
+	return ruleField.BaseType.Name()
+}
+
+// emptyMatchError returns an error if no field matched anything in a Seq,
+// unless the rule allows it.
+func (f *ruleFrame) emptyMatchError(s *ParserState) *ParseError {
+	if f.itemCount > 0 || f.ruleDef.AllowEmpty {
+		return nil
+	}
+	pos := s.Save()
+	tok := s.Next()
+	return &ParseError{
+		Token: tok,
+		Err:   fmt.Errorf("empty match for rule %s", f.ruleDef.Name),
+		Pos:   pos,
+	}
+}
+
+// coverField records an attempt at matching the field at position i in the
+// rule if coverage is enabled.
+func (s *ParserState) coverField(ruleDef *RuleDef, i int, matched bool, count int) {
+	if s.coverage != nil {
+		s.coverage.record(ruleDef, i, matched, count)
+	}
+}
+
+// parseField parses dest as the value of a field of a rule.  The index is the
+// position of the item for repeated fields and -1 otherwise.
+func parseField(dest interface{}, s *ParserState, ruleDef *RuleDef, ruleField RuleField, index int) *ParseError {
+	if s.spans == nil {
+		return ParseWithOptions(dest, s, ruleField.TokenOptions)
+	}
+	start := s.enterField(ruleDef, ruleField, index)
+	err := ParseWithOptions(dest, s, ruleField.TokenOptions)
+	s.exitField(start, err)
+	return err
+}
+
+// enterField is called before parsing the value of a field when spans are
+// recorded.  It returns the start position of the value.
+func (s *ParserState) enterField(ruleDef *RuleDef, ruleField RuleField, index int) int {
+	s.path = append(s.path, PathStep{Rule: ruleDef.Name, Field: ruleField.Name, Index: index})
+	return s.Save()
+}
+
+// exitField is called after parsing the value of a field when spans are
+// recorded, with the result.
+func (s *ParserState) exitField(start int, err *ParseError) {
+	if err == nil {
+		s.spans[s.path.String()] = Span{Start: start, End: s.Save()}
+	}
+	s.path = s.path[:len(s.path)-1]
+}
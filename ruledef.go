@@ -4,15 +4,22 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 type RuleDef struct {
 	Name        string
 	OneOf       bool
+	Longest     bool // True if a OneOf rule keeps its longest match (see Longest)
+	TieError    bool // True if a Longest rule fails when alternatives tie, set with ties:"error"
+	AllowEmpty  bool // True if a Seq rule may match no tokens (e.g. Optional)
 	DropOptions TokenOptions
 	Fields      []RuleField
+
+	customParse bool // True if the rule type implements CustomParser
 }
 
 type RuleField struct {
@@ -20,10 +27,64 @@ type RuleField struct {
 	TokenOptions
 	SizeOptions
 	SepOptions TokenOptions
+	Leading    SepPolicy // Separator before the first item, set with the leading tag
+	Trailing   SepPolicy // Separator after the last item, set with the trailing tag
+	Lookahead  Lookahead
 	Name       string
 	Index      int
+
+	// SepRule is the rule type of the separators when they are parsed as
+	// rules rather than tokens, set with sep:"@Name" or term:"@Name" where
+	// Name is registered with RegisterRule.  Like tokens matched by Match
+	// fields, the separators are not kept in the tree.
+	SepRule reflect.Type
+
+	// Commit is true if the field follows a Commit field, so that the rule
+	// is committed when parsing reaches it.  Commit fields themselves are
+	// not included in the rule definition.
+	Commit bool
 }
 
+// Lookahead is the kind of lookahead of a field in a Seq rule, set with the
+// lookahead tag.  A lookahead field is parsed then the token stream is always
+// restored, so it consumes no tokens, and the field is left with its zero
+// value.  E.g. in
+//
+//	type Assign struct {
+//	    grammar.Seq
+//	    Name    Token         `tok:"ident"`
+//	    NotKw   Keyword       `lookahead:"not"`
+//	    Eq      grammar.Match `tok:"op,="`
+//	    ...
+//	}
+type Lookahead uint8
+
+const (
+	NoLookahead  Lookahead = iota // The field is parsed normally
+	AndLookahead                  // lookahead:"and", the field must match
+	NotLookahead                  // lookahead:"not", the field must not match
+)
+
+// SepPolicy says whether a separator is expected before the first item or
+// after the last item of a repeated field with a sep tag.  E.g. to allow a
+// trailing comma in a list:
+//
+//	Items []Value `sep:"op,," trailing:"allow"`
+//
+// When separators are forbidden after the last item, a separator which is not
+// followed by an item is an error (see ErrDanglingSeparator).
+type SepPolicy uint8
+
+const (
+	SepForbid  SepPolicy = iota // There must be no separator (the default)
+	SepAllow                    // There may be a separator
+	SepRequire                  // There must be a separator if there are items
+)
+
+// ErrDanglingSeparator is wrapped in the error returned when a separator is
+// not followed by an item and trailing separators are forbidden.
+var ErrDanglingSeparator = errors.New("dangling separator")
+
 type FieldType struct {
 	BaseType reflect.Type
 	Pointer  bool
@@ -34,6 +95,11 @@ type TokenParseOptions struct {
 	TokenType    string
 	TokenValue   string
 	DoNotConsume bool
+	IgnoreCase   bool   // The value is compared case-insensitively
+	ValuePattern string // A regular expression matching the value, instead of TokenValue
+	Class        string // The token class the options belong to, if any
+
+	valueRegexp *regexp.Regexp // Compiled from ValuePattern by the tag parser
 }
 
 func (o TokenParseOptions) String() string {
@@ -41,8 +107,8 @@ func (o TokenParseOptions) String() string {
 	if o.TokenType != "" {
 		parts = append(parts, fmt.Sprintf("type %s", o.TokenType))
 	}
-	if o.TokenValue != "" {
-		parts = append(parts, fmt.Sprintf("value %q", o.TokenValue))
+	if label := o.valueLabel(); label != "" {
+		parts = append(parts, "value "+label)
 	}
 	if len(parts) == 0 {
 		return "any type"
@@ -50,6 +116,41 @@ func (o TokenParseOptions) String() string {
 	return strings.Join(parts, ",")
 }
 
+// valueLabel describes the values matched by the options, e.g. "if",
+// "select"i or /[a-z]+/.  It is empty if any value matches.
+func (o TokenParseOptions) valueLabel() string {
+	var label string
+	switch {
+	case o.ValuePattern != "":
+		label = "/" + o.ValuePattern + "/"
+	case o.TokenValue != "":
+		label = strconv.Quote(o.TokenValue)
+	default:
+		return ""
+	}
+	if o.IgnoreCase {
+		label += "i"
+	}
+	return label
+}
+
+// matches returns true if tok satisfies the options.
+func (o TokenParseOptions) matches(tok Token) bool {
+	if o.TokenType != "" && o.TokenType != tok.Type() {
+		return false
+	}
+	switch {
+	case o.valueRegexp != nil:
+		return o.valueRegexp.MatchString(tok.Value())
+	case o.TokenValue == "":
+		return true
+	case o.IgnoreCase:
+		return strings.EqualFold(o.TokenValue, tok.Value())
+	default:
+		return o.TokenValue == tok.Value()
+	}
+}
+
 type TokenOptions struct {
 	TokenParseOptions []TokenParseOptions
 }
@@ -66,16 +167,13 @@ func (o TokenOptions) MatchNextToken(s TokenStream) (Token, *ParseError) {
 	tok := s.Next()
 
 	for _, opts := range o.TokenParseOptions {
-		if opts.TokenType != "" && opts.TokenType != tok.Type() {
+		if !opts.matches(tok) {
 			continue
-			// return fmt.Errorf("expected token of type %s", opts.TokenType)
-		}
-		if opts.TokenValue != "" && opts.TokenValue != tok.Value() {
-			continue
-			// return fmt.Errorf("expected token with value %q", opts.TokenValue)
 		}
 		if opts.DoNotConsume {
 			s.Restore(pos)
+		} else if ps, ok := traceStream(s); ok {
+			ps.trace(TraceEvent{Kind: TraceConsume, Token: tok, Pos: pos})
 		}
 		return tok, nil
 	}
@@ -97,13 +195,12 @@ outerLoop:
 		tok := s.Next()
 
 		for _, opts := range o.TokenParseOptions {
-			if opts.TokenType != "" && opts.TokenType != tok.Type() {
-				continue
+			if opts.matches(tok) {
+				if ps, ok := traceStream(s); ok {
+					ps.trace(TraceEvent{Kind: TraceDrop, Token: tok, Pos: pos})
+				}
+				continue outerLoop
 			}
-			if opts.TokenValue != "" && opts.TokenValue != tok.Value() {
-				continue
-			}
-			continue outerLoop
 		}
 		s.Restore(pos)
 		return
@@ -128,6 +225,8 @@ func getRuleDefAndValue(r interface{}) (*RuleDef, reflect.Value) {
 }
 
 func getRuleDef(tp reflect.Type) (*RuleDef, error) {
+	ruleDefCacheMu.Lock()
+	defer ruleDefCacheMu.Unlock()
 	cached, ok := ruleDefCache[tp]
 	if ok {
 		return cached.ruleDef, cached.err
@@ -145,7 +244,12 @@ type ruleDefCacheValue struct {
 	err     error
 }
 
-var ruleDefCache = map[reflect.Type]ruleDefCacheValue{}
+// ruleDefCache is guarded by ruleDefCacheMu so that parses can run
+// concurrently.
+var (
+	ruleDefCache   = map[reflect.Type]ruleDefCacheValue{}
+	ruleDefCacheMu sync.Mutex
+)
 
 func calcRuleDef(tp reflect.Type) (*RuleDef, error) {
 	if tp.Kind() != reflect.Struct {
@@ -157,27 +261,66 @@ func calcRuleDef(tp reflect.Type) (*RuleDef, error) {
 	}
 	firstFieldIndex := 0
 	field0 := tp.Field(0)
-	oneOf := field0.Type == reflect.TypeOf(OneOf{})
+	longest := field0.Type == reflect.TypeOf(Longest{})
+	oneOf := longest || field0.Type == reflect.TypeOf(OneOf{})
 	seq := field0.Type == reflect.TypeOf(Seq{})
 	var dropOptions TokenOptions
+	tieError := false
 	if oneOf || seq {
 		firstFieldIndex++
-		dropOptions = tokenOptionsFromTagValue(field0.Tag.Get("drop"))
+		var err error
+		dropOptions, err = tokenOptionsFromTagValue(field0.Tag.Get("drop"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid drop tag on field %s: %w", field0.Name, err)
+		}
+		switch ties := field0.Tag.Get("ties"); {
+		case ties == "" || ties == "first":
+		case ties == "error" && longest:
+			tieError = true
+		default:
+			return nil, fmt.Errorf("invalid ties tag %q", ties)
+		}
 	} else {
-		return nil, errors.New("first rule field should be OneOf or Seq")
+		return nil, errors.New("first rule field should be OneOf, Longest or Seq")
 	}
 
 	var ruleFields []RuleField
+	commit := false
 	for fieldIndex := firstFieldIndex; fieldIndex < numField; fieldIndex++ {
 		field := tp.Field(fieldIndex)
 		sizeOpts, err := sizeOptionsFromTagValue(field.Tag.Get("size"))
 		if err != nil {
 			return nil, err
 		}
+		lookahead, err := lookaheadFromTagValue(field.Tag.Get("lookahead"))
+		if err != nil {
+			return nil, err
+		}
+		leading, err := sepPolicyFromTagValue(field.Tag.Get("leading"))
+		if err != nil {
+			return nil, err
+		}
+		trailing, err := sepPolicyFromTagValue(field.Tag.Get("trailing"))
+		if err != nil {
+			return nil, err
+		}
+		sep := field.Tag.Get("sep")
+		if term := field.Tag.Get("term"); term != "" {
+			if sep != "" || leading != SepForbid || trailing != SepForbid {
+				return nil, errors.New("term tag cannot be combined with sep, leading or trailing tags")
+			}
+			sep, trailing = term, SepRequire
+		}
+		tokOpts, err := tokenOptionsFromTagValue(field.Tag.Get("tok"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid tok tag on field %s: %w", field.Name, err)
+		}
 		ruleField := RuleField{
-			TokenOptions: tokenOptionsFromTagValue(field.Tag.Get("tok")),
-			SepOptions:   tokenOptionsFromTagValue(field.Tag.Get("sep")),
+			TokenOptions: tokOpts,
 			SizeOptions:  sizeOpts,
+			Leading:      leading,
+			Trailing:     trailing,
+			Lookahead:    lookahead,
 			Name:         field.Name,
 			Index:        fieldIndex,
 		}
@@ -200,14 +343,48 @@ func calcRuleDef(tp reflect.Type) (*RuleDef, error) {
 				BaseType: field.Type,
 			}
 		}
+		if strings.HasPrefix(sep, "@") {
+			var ok bool
+			if ruleField.SepRule, ok = lookupRule(sep[1:]); !ok {
+				return nil, fmt.Errorf("unknown rule %q in sep or term tag on field %s", sep[1:], field.Name)
+			}
+		} else if ruleField.SepOptions, err = tokenOptionsFromTagValue(sep); err != nil {
+			return nil, fmt.Errorf("invalid sep tag on field %s: %w", field.Name, err)
+		}
+		if ruleField.BaseType == commitType {
+			if oneOf || ruleField.Pointer || ruleField.Array {
+				return nil, errors.New("Commit fields must be in a Seq and not be pointers or slices")
+			}
+			commit = true
+			continue
+		}
+		if (leading != SepForbid || trailing != SepForbid) && (!ruleField.Array || !ruleField.hasSep()) {
+			return nil, errors.New("leading and trailing tags require a repeated field with a sep tag")
+		}
+		if ruleField.SepRule != nil && !ruleField.Array {
+			return nil, errors.New("sep and term tags require a repeated field")
+		}
+		if lookahead != NoLookahead && (oneOf || ruleField.Pointer || ruleField.Array) {
+			return nil, errors.New("lookahead fields must be in a Seq and not be pointers or slices")
+		}
+		ruleField.Commit = commit
+		commit = false
 		ruleFields = append(ruleFields, ruleField)
 	}
-	return &RuleDef{
-		Name:        tp.Name(),
+	if commit {
+		return nil, errors.New("a Commit field must be followed by another field")
+	}
+	ruleDef := &RuleDef{
+		Name:        ruleTypeName(tp),
 		OneOf:       oneOf,
+		Longest:     longest,
+		TieError:    tieError,
+		AllowEmpty:  tp.Implements(emptyAllowerType),
 		Fields:      ruleFields,
 		DropOptions: dropOptions,
-	}, nil
+		customParse: reflect.PtrTo(tp).Implements(customParserType),
+	}
+	return ruleDef, nil
 }
 
 func sizeOptionsFromTagValue(v string) (opts SizeOptions, err error) {
@@ -231,28 +408,33 @@ func sizeOptionsFromTagValue(v string) (opts SizeOptions, err error) {
 	return
 }
 
-func tokenOptionsFromTagValue(v string) TokenOptions {
-	var opts []TokenParseOptions
-	for _, optStr := range strings.Split(v, "|") {
-		if optStr == "" {
-			continue
-		}
-		var tt, tv string
-		if i := strings.IndexByte(optStr, ','); i >= 0 {
-			tt = optStr[:i]
-			tv = optStr[i+1:]
-		} else {
-			tt = optStr
-		}
-		dnc := tt[len(tt)-1] == '*'
-		if dnc {
-			tt = tt[:len(tt)-1]
-		}
-		opts = append(opts, TokenParseOptions{
-			TokenType:    tt,
-			TokenValue:   tv,
-			DoNotConsume: dnc,
-		})
+func lookaheadFromTagValue(v string) (Lookahead, error) {
+	switch v {
+	case "":
+		return NoLookahead, nil
+	case "and":
+		return AndLookahead, nil
+	case "not":
+		return NotLookahead, nil
+	default:
+		return NoLookahead, fmt.Errorf("invalid lookahead %q, should be and or not", v)
 	}
-	return TokenOptions{TokenParseOptions: opts}
+}
+
+func sepPolicyFromTagValue(v string) (SepPolicy, error) {
+	switch v {
+	case "", "forbid":
+		return SepForbid, nil
+	case "allow":
+		return SepAllow, nil
+	case "require":
+		return SepRequire, nil
+	default:
+		return SepForbid, fmt.Errorf("invalid separator policy %q, should be forbid, allow or require", v)
+	}
+}
+
+// hasSep returns true if the items of a repeated field are separated.
+func (f RuleField) hasSep() bool {
+	return len(f.SepOptions.TokenParseOptions) > 0 || f.SepRule != nil
 }
@@ -0,0 +1,164 @@
+package grammar
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type spForbid struct {
+	Seq
+	Open  Match         `tok:"op,("`
+	Items []SimpleToken `tok:"number" sep:"op,,"`
+	Close Match         `tok:"op,)"`
+}
+
+type spAllowTrailing struct {
+	Seq
+	Open  Match         `tok:"op,("`
+	Items []SimpleToken `tok:"number" sep:"op,," trailing:"allow"`
+	Close Match         `tok:"op,)"`
+}
+
+type spRequireTrailing struct {
+	Seq
+	Open  Match         `tok:"op,("`
+	Items []SimpleToken `tok:"number" sep:"op,," trailing:"require"`
+	Close Match         `tok:"op,)"`
+}
+
+type spAllowLeading struct {
+	Seq
+	Open  Match         `tok:"op,("`
+	Items []SimpleToken `tok:"number" sep:"op,," leading:"allow"`
+	Close Match         `tok:"op,)"`
+}
+
+type spRequireLeading struct {
+	Seq
+	Open  Match         `tok:"op,("`
+	Items []SimpleToken `tok:"number" sep:"op,," leading:"require"`
+	Close Match         `tok:"op,)"`
+}
+
+// The items are followed by a comma which is not part of the list.
+type spMax struct {
+	Seq
+	Items []SimpleToken `tok:"number" sep:"op,," size:"-2"`
+	Comma Match         `tok:"op,,"`
+	Last  SimpleToken   `tok:"number"`
+}
+
+type spOneOf struct {
+	OneOf
+	Items []SimpleToken `tok:"number" sep:"op,," size:"-2"`
+	Paren *spForbid
+}
+
+func TestSeparatorPolicy(t *testing.T) {
+	tests := []struct {
+		dest interface{}
+		src  string
+		want string
+	}{
+		{&spForbid{}, "(1, 2)", "1 2"},
+		{&spForbid{}, "()", ""},
+		{&spForbid{}, "(1, 2,)", `error: token #5 op with value ")": dangling separator, expected number`},
+		{&spForbid{}, "(, 1)", `error: token #1 op with value ",": expected token with type number, or value ")"`},
+		{&spAllowTrailing{}, "(1, 2,)", "1 2"},
+		{&spAllowTrailing{}, "(1, 2)", "1 2"},
+		{&spAllowTrailing{}, "(1, 2,,)", `error: token #5 op with value ",": expected token with type number, or value ")"`},
+		{&spRequireTrailing{}, "(1, 2,)", "1 2"},
+		{&spRequireTrailing{}, "()", ""},
+		{&spRequireTrailing{}, "(1, 2)", `error: token #4 op with value ")": expected token with value ","`},
+		{&spAllowLeading{}, "(, 1, 2)", "1 2"},
+		{&spAllowLeading{}, "(1, 2)", "1 2"},
+		{&spAllowLeading{}, "(,)", `error: token #2 op with value ")": dangling separator, expected number`},
+		{&spRequireLeading{}, "(, 1, 2)", "1 2"},
+		{&spRequireLeading{}, "()", ""},
+		{&spRequireLeading{}, "(1, 2)", `error: token #1 number with value "1": expected token with value ")" or ","`},
+		{&spMax{}, "1, 2, 3", "1 2"},
+		{&spMax{}, "1, 2", `error: token #3 EOF with value "EOF": expected token with value ","`},
+		{&spOneOf{}, "1, 2, 3", "1 2"},
+		{&spOneOf{}, "(1, 2)", "1 2"},
+	}
+	for _, test := range tests {
+		name := fmt.Sprintf("%T %s", test.dest, test.src)
+		t.Run(name, func(t *testing.T) {
+			res := parseBothEngines(t, test.dest, test.src)
+			var got string
+			if res.Err != nil {
+				got = "error: " + res.Err.Error()
+			} else {
+				var values []string
+				Walk(res.Tree, func(node interface{}, path Path) WalkAction {
+					if tok, ok := node.(SimpleToken); ok && tok.Type() == "number" && !strings.HasSuffix(path.String(), "Last") {
+						values = append(values, tok.Value())
+					}
+					return WalkContinue
+				})
+				got = strings.Join(values, " ")
+			}
+			if got != test.want {
+				t.Errorf("Got:\n%s\nWant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDanglingSeparatorError(t *testing.T) {
+	stream, err := testLangTokenise("(1,)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dest spForbid
+	if err := Parse(&dest, stream); !errors.Is(err, ErrDanglingSeparator) {
+		t.Errorf("Got %v, want ErrDanglingSeparator", err)
+	}
+}
+
+func TestUnparseSeparatorPolicy(t *testing.T) {
+	items := []SimpleToken{{TokType: "number", TokValue: "1"}, {TokType: "number", TokValue: "2"}}
+	tests := []struct {
+		r    interface{}
+		want string
+	}{
+		{spForbid{Items: items}, "( 1 , 2 )"},
+		{spAllowTrailing{Items: items}, "( 1 , 2 )"},
+		{spRequireTrailing{Items: items}, "( 1 , 2 , )"},
+		{spRequireLeading{Items: items}, "( , 1 , 2 )"},
+		{spRequireLeading{}, "( )"},
+		{spOneOf{Items: items}, "1 , 2"},
+	}
+	for _, test := range tests {
+		toks, err := Unparse(test.r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var values []string
+		for _, tok := range toks {
+			values = append(values, tok.Value())
+		}
+		if got := strings.Join(values, " "); got != test.want {
+			t.Errorf("Unparse(%T): got %q, want %q", test.r, got, test.want)
+		}
+	}
+}
+
+func TestSeparatorPolicyInvalid(t *testing.T) {
+	type noSep struct {
+		Seq
+		Items []SimpleToken `tok:"number" trailing:"allow"`
+	}
+	type badTag struct {
+		Seq
+		Items []SimpleToken `tok:"number" sep:"op,," trailing:"maybe"`
+	}
+	for _, r := range []interface{}{noSep{}, badTag{}} {
+		if _, err := calcRuleDef(reflect.TypeOf(r)); err == nil {
+			t.Errorf("Expected error for %T", r)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package grammar
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A newline or a semicolon.
+type stSep struct {
+	OneOf
+	Newline *SimpleToken `tok:"nl"`
+	Semi    *SimpleToken `tok:"op,;"`
+}
+
+// A semicolon, which can be recreated by Unparse.
+type stSemi struct {
+	Seq
+	Semi Match `tok:"op,;"`
+}
+
+func init() {
+	MustRegisterRule(stSep{})
+	MustRegisterRule(stSemi{})
+}
+
+type stBlock struct {
+	Seq
+	Open  Match         `tok:"op,{"`
+	Stmts []SimpleToken `tok:"ident" sep:"@stSep"`
+	Close Match         `tok:"op,}"`
+}
+
+type stSemiBlock struct {
+	Seq
+	Open  Match         `tok:"op,{"`
+	Stmts []SimpleToken `tok:"ident" sep:"@stSemi"`
+	Close Match         `tok:"op,}"`
+}
+
+type stTerminated struct {
+	Seq
+	Stmts []SimpleToken `tok:"ident" term:"@stSep"`
+}
+
+type stTokenTerm struct {
+	Seq
+	Stmts []SimpleToken `tok:"ident" term:"op,;"`
+}
+
+func TestRuleSeparators(t *testing.T) {
+	tests := []struct {
+		dest interface{}
+		src  string
+		want string
+	}{
+		{&stBlock{}, "{a; b\nc}", "a b c @7"},
+		{&stBlock{}, "{}", "@2"},
+		{&stBlock{}, "{a;}", `error: token #3 op with value "}": dangling separator, expected ident`},
+		{&stBlock{}, "{a b}", `error: token #2 ident with value "b": expected token with type nl, or value ";" or "}"`},
+		{&stSemiBlock{}, "{a; b}", "a b @5"},
+		{&stTerminated{}, "a; b\n", "a b @4"},
+		{&stTerminated{}, "a; b", "a @2"},
+		{&stTokenTerm{}, "a; b;", "a b @4"},
+		{&stTokenTerm{}, "a; b", "a @2"},
+	}
+	for _, test := range tests {
+		name := fmt.Sprintf("%T %q", test.dest, test.src)
+		t.Run(name, func(t *testing.T) {
+			res := parseBothEngines(t, test.dest, test.src)
+			var got string
+			if res.Err != nil {
+				got = "error: " + res.Err.Error()
+			} else {
+				var values []string
+				stmts := reflect.ValueOf(res.Tree).Elem().FieldByName("Stmts").Interface().([]SimpleToken)
+				for _, stmt := range stmts {
+					values = append(values, stmt.Value())
+				}
+				values = append(values, fmt.Sprintf("@%d", res.Pos))
+				got = strings.Join(values, " ")
+			}
+			if got != test.want {
+				t.Errorf("Got:\n%s\nWant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+// Separators are not kept in the tree, so Unparse recreates them from the
+// separator rule.
+func TestUnparseRuleSeparators(t *testing.T) {
+	a := SimpleToken{TokType: "ident", TokValue: "a"}
+	b := SimpleToken{TokType: "ident", TokValue: "b"}
+	tests := []struct {
+		tree interface{}
+		want string
+	}{
+		{&stSemiBlock{Stmts: []SimpleToken{a, b}}, "{ a ; b }"},
+		{&stSemiBlock{}, "{ }"},
+		{&stBlock{Stmts: []SimpleToken{a}}, "{ a }"},
+		{&stBlock{Stmts: []SimpleToken{a, b}}, "error: stBlock.Stmts: cannot recreate separator stSep"},
+	}
+	for _, test := range tests {
+		var got string
+		toks, err := Unparse(test.tree)
+		if err != nil {
+			got = "error: " + err.Error()
+		} else {
+			var values []string
+			for _, tok := range toks {
+				values = append(values, tok.Value())
+			}
+			got = strings.Join(values, " ")
+		}
+		if !strings.HasPrefix(got, test.want) {
+			t.Errorf("%T: got %q, want %q", test.tree, got, test.want)
+		}
+	}
+}
+
+func TestRuleSeparatorsInvalid(t *testing.T) {
+	type unknown struct {
+		Seq
+		Items []SimpleToken `tok:"ident" sep:"@stUnknown"`
+	}
+	type notSlice struct {
+		Seq
+		Item SimpleToken `tok:"ident" sep:"@stSep"`
+	}
+	type termAndSep struct {
+		Seq
+		Items []SimpleToken `tok:"ident" sep:"op,;" term:"op,;"`
+	}
+	for _, r := range []interface{}{unknown{}, notSlice{}, termAndSep{}} {
+		if _, err := calcRuleDef(reflect.TypeOf(r)); err == nil {
+			t.Errorf("Expected error for %T", r)
+		}
+	}
+	if err := RegisterRule(stSep{}); err == nil {
+		t.Errorf("Expected error registering stSep twice")
+	}
+}
@@ -0,0 +1,108 @@
+package grammar
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A small s-expression grammar used by tests in this package.
+
+type testToken = SimpleToken
+
+type testExpr struct {
+	OneOf
+	Number *testToken `tok:"number"`
+	Atom   *testToken `tok:"atom"`
+	List   *testList
+}
+
+type testList struct {
+	Seq
+	Open  Match `tok:"bkt,("`
+	Items []testExpr
+	Close Match `tok:"bkt,)"`
+}
+
+var testTokenise = SimpleTokeniser([]TokenDef{
+	{Ptn: `\s+`},
+	{Name: "bkt", Ptn: `[()]`},
+	{Name: "number", Ptn: `[0-9]+`},
+	{Name: "atom", Ptn: `[a-z]+`},
+})
+
+func mustParseTestExpr(t *testing.T, src string) *testExpr {
+	t.Helper()
+	stream, err := testTokenise(src)
+	if err != nil {
+		t.Fatalf("Error tokenising %q: %s", src, err)
+	}
+	var expr testExpr
+	if err := Parse(&expr, stream); err != nil {
+		t.Fatalf("Error parsing %q: %s", src, err)
+	}
+	return &expr
+}
+
+// testLangTokenise tokenises the small languages of tests which define their
+// own grammar.  Newlines are tokens.
+var testLangTokenise = SimpleTokeniser([]TokenDef{
+	{Ptn: `[ \t]+`},
+	{Name: "nl", Ptn: `\n`},
+	{Name: "comment", Ptn: `#[a-z]*`},
+	{Name: "op", Ptn: `\|\||[-+*/=<>;,|()\[\]{}]`},
+	{Name: "number", Ptn: `[0-9]+`},
+	{Name: "ident", Ptn: `[a-zA-Z]+`},
+})
+
+// testEngines are the options selecting each parse engine.
+var testEngines = [][]ParseOption{nil, {WithIterativeEngine()}}
+
+// A testResult is the outcome of parsing an input.
+type testResult struct {
+	Tree interface{} // Pointer to the parsed value, nil if there is an error
+	Err  *ParseError
+	Pos  int // Position reached in the token stream
+}
+
+// String returns the error, or the compact pretty tree and the position
+// reached.
+func (r testResult) String() string {
+	return r.format(PrettyOptions{Compact: true})
+}
+
+// format is like String but the tree is written with the given options.
+func (r testResult) format(opts PrettyOptions) string {
+	if r.Err != nil {
+		return "error: " + r.Err.Error()
+	}
+	var b strings.Builder
+	PrettyWriteWithOptions(&b, reflect.ValueOf(r.Tree).Elem().Interface(), opts)
+	return fmt.Sprintf("%s @%d", strings.TrimSpace(b.String()), r.Pos)
+}
+
+// parseBothEngines tokenises src with testLangTokenise and parses it into a
+// new value of the type dest points to, with each engine.  It fails the test
+// if the engines give different results.
+func parseBothEngines(t *testing.T, dest interface{}, src string, opts ...ParseOption) testResult {
+	t.Helper()
+	var results []testResult
+	for _, engineOpts := range testEngines {
+		stream, err := testLangTokenise(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := testResult{Tree: reflect.New(reflect.TypeOf(dest).Elem()).Interface()}
+		res.Err = Parse(res.Tree, stream, append(engineOpts[:len(engineOpts):len(engineOpts)], opts...)...)
+		if res.Err != nil {
+			res.Tree = nil
+		}
+		res.Pos = stream.Save()
+		results = append(results, res)
+	}
+	if !reflect.DeepEqual(results[0], results[1]) {
+		t.Fatalf("Engines differ on %q\nRecursive: %s\nIterative: %s", src, results[0], results[1])
+	}
+	return results[0]
+}
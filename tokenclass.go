@@ -0,0 +1,86 @@
+package grammar
+
+import (
+	"fmt"
+	"sync"
+	"unicode"
+)
+
+// tokenClasses maps the names of token classes to the options they match.  It
+// is guarded by tokenClassesMu so that classes can be defined while rules are
+// being parsed.
+var (
+	tokenClasses   = map[string][]TokenParseOptions{}
+	tokenClassesMu sync.RWMutex
+)
+
+// DefineTokenClass registers a named token class, which can then be used in
+// tok, sep, term and drop tags as #name.  The tag has the same syntax as a tok
+// tag, e.g.
+//
+//	grammar.DefineTokenClass("binop", "op,+|op,-|op,*|op,/")
+//
+//	type BinExpr struct {
+//	    grammar.Seq
+//	    Left  Term
+//	    Op    Token `tok:"#binop"`
+//	    Right Term
+//	}
+//
+// Parse errors mention the name of the class rather than its members.  As rule
+// definitions are cached, a class must be defined before the rules using it
+// are parsed for the first time, e.g. in an init function.
+func DefineTokenClass(name, tag string) error {
+	if !isTokenClassName(name) {
+		return fmt.Errorf("invalid token class name %q", name)
+	}
+	if _, ok := lookupTokenClass(name); ok {
+		return fmt.Errorf("token class %q already defined", name)
+	}
+	opts, err := tokenOptionsFromTagValue(tag)
+	if err != nil {
+		return fmt.Errorf("invalid tag for token class %s: %w", name, err)
+	}
+	if len(opts.TokenParseOptions) == 0 {
+		return fmt.Errorf("empty token class %s", name)
+	}
+	for i := range opts.TokenParseOptions {
+		opts.TokenParseOptions[i].Class = name
+	}
+	// The tag may refer to other classes, so the lock is only taken once it
+	// has been parsed.
+	tokenClassesMu.Lock()
+	defer tokenClassesMu.Unlock()
+	if _, ok := tokenClasses[name]; ok {
+		return fmt.Errorf("token class %q already defined", name)
+	}
+	tokenClasses[name] = opts.TokenParseOptions
+	return nil
+}
+
+func lookupTokenClass(name string) ([]TokenParseOptions, bool) {
+	tokenClassesMu.RLock()
+	defer tokenClassesMu.RUnlock()
+	opts, ok := tokenClasses[name]
+	return opts, ok
+}
+
+// MustDefineTokenClass is like DefineTokenClass but panics if the class cannot
+// be defined.
+func MustDefineTokenClass(name, tag string) {
+	if err := DefineTokenClass(name, tag); err != nil {
+		panic(err)
+	}
+}
+
+func isTokenClassName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
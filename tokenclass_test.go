@@ -0,0 +1,139 @@
+package grammar
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func init() {
+	MustDefineTokenClass("tcBinop", "op,+|op,-|op,*|op,/")
+	MustDefineTokenClass("tcSep", "op,;|op,','")
+	MustDefineTokenClass("tcComment", "comment")
+}
+
+type tcExpr struct {
+	Seq   `drop:"#tcComment"`
+	Left  SimpleToken `tok:"number"`
+	Op    SimpleToken `tok:"#tcBinop"`
+	Right SimpleToken `tok:"number"`
+}
+
+type tcList struct {
+	Seq
+	Exprs []tcExpr `sep:"#tcSep"`
+	End   Match    `tok:"EOF"`
+}
+
+func TestTokenClasses(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"1 + 2; 3 #x * 4, 5 / 6", "+ * /"},
+		{"1 = 2", `token #1 op with value "=": expected tcBinop`},
+		{"1 + 2 3", `token #3 number with value "3": expected token with type EOF`},
+		{"1 + 2;", `token #4 EOF with value "EOF": dangling separator, expected tcExpr`},
+	}
+	for _, test := range tests {
+		t.Run(test.src, func(t *testing.T) {
+			res := parseBothEngines(t, &tcList{}, test.src)
+			var got string
+			if res.Err != nil {
+				got = res.Err.Error()
+			} else {
+				var ops []string
+				for _, e := range res.Tree.(*tcList).Exprs {
+					ops = append(ops, e.Op.Value())
+				}
+				got = strings.Join(ops, " ")
+			}
+			if got != test.want {
+				t.Errorf("Got:\n%s\nWant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTokenClassTags(t *testing.T) {
+	opts, err := tokenOptionsFromTagValue("#tcSep*|op,=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TokenParseOptions{
+		{TokenType: "op", TokenValue: ";", DoNotConsume: true, Class: "tcSep"},
+		{TokenType: "op", TokenValue: ",", DoNotConsume: true, Class: "tcSep"},
+		{TokenType: "op", TokenValue: "="},
+	}
+	if !reflect.DeepEqual(opts.TokenParseOptions, want) {
+		t.Errorf("Got %+v, want %+v", opts.TokenParseOptions, want)
+	}
+	if got := tokenOptionsLabel(opts); got != `tcSep | op "="` {
+		t.Errorf("Got label %q", got)
+	}
+	for _, tag := range []string{"#nope", "#tcSep,x"} {
+		if _, err := tokenOptionsFromTagValue(tag); err == nil {
+			t.Errorf("%q: expected an error", tag)
+		}
+	}
+}
+
+func TestDefineTokenClassErrors(t *testing.T) {
+	tests := []struct {
+		name, tag string
+	}{
+		{"", "op"},
+		{"bad name", "op"},
+		{"tcBinop", "op"},
+		{"tcEmpty", ""},
+		{"tcInvalid", "op,/(/"},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%q", test.name), func(t *testing.T) {
+			if err := DefineTokenClass(test.name, test.tag); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+// Run with -race to check that classes can be defined while rules using
+// other classes are parsed.
+func TestDefineTokenClassConcurrently(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if err := DefineTokenClass(fmt.Sprintf("tcConcurrent%d", i), "op,="); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				// Rule definitions are cached, so compute them directly to
+				// look up the classes each time.
+				if _, err := calcRuleDef(reflect.TypeOf(tcExpr{})); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			stream, err := testLangTokenise("1 + 2; 3 * 4")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			var list tcList
+			if err := Parse(&list, stream); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,223 @@
+package grammar
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// tokenOptionsFromTagValue parses the value of a tok, sep, term or drop tag.
+// It is a list of alternatives separated by "|", each of the form
+//
+//	type[*][,value]
+//
+// where type is the token type (any type if it is empty) and "*" means the
+// token is not consumed.  The value can be
+//
+//	abc      the rest of the alternative, which cannot contain "|"
+//	'a|b'    a quoted value, where \' and \\ are escapes for ' and \
+//	/[a-z]+/ a regular expression which must match the whole value, where \/
+//	         is an escape for /
+//
+// A quoted value or a regular expression can be followed by the flag i to
+// make the match case-insensitive, e.g. kw,'select'i.  Other values starting
+// with ' or / (e.g. op,/= or op,//) are read as they are.
+//
+// An alternative can also be #name[*], which stands for all the options of
+// the token class name (see DefineTokenClass).
+//
+// Before quoted values and regular expressions were introduced, the value was
+// always the rest of the alternative, so a tag such as op,'x' or op,/x/ used
+// to match the value with the quotes or slashes.  Such values must now be
+// quoted:
+//
+//	op,'\'x\''  matches 'x'
+//	op,'/x/'    matches /x/
+//
+// Other tags keep their meaning: empty alternatives are ignored, a lone *
+// matches any token without consuming it and the type is everything before the
+// first comma.
+func tokenOptionsFromTagValue(v string) (TokenOptions, error) {
+	var opts []TokenParseOptions
+	if v == "" {
+		return TokenOptions{}, nil
+	}
+	p := tagParser{src: v}
+	for {
+		var err error
+		switch p.peek() {
+		case 0, '|':
+			// Empty alternatives are ignored.
+		case '#':
+			opts, err = p.class(opts)
+		default:
+			var opt TokenParseOptions
+			opt, err = p.option()
+			opts = append(opts, opt)
+		}
+		if err != nil {
+			return TokenOptions{}, err
+		}
+		if p.done() {
+			return TokenOptions{TokenParseOptions: opts}, nil
+		}
+		p.pos++ // Skip "|"
+	}
+}
+
+// tagParser is a scanner for the value of a tok tag.
+type tagParser struct {
+	src string
+	pos int
+}
+
+func (p *tagParser) done() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *tagParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *tagParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// option parses one alternative, stopping before the "|" that follows it.
+func (p *tagParser) option() (opt TokenParseOptions, err error) {
+	start := p.pos
+	for !p.done() && !strings.ContainsRune(",|", rune(p.peek())) {
+		p.pos++
+	}
+	opt.TokenType = p.src[start:p.pos]
+	if n := len(opt.TokenType); n > 0 && opt.TokenType[n-1] == '*' {
+		opt.TokenType = opt.TokenType[:n-1]
+		opt.DoNotConsume = true
+	}
+	if p.peek() == ',' {
+		p.pos++
+		opt, err = p.value(opt)
+	}
+	if err == nil && opt == (TokenParseOptions{}) {
+		p.pos = start
+		return opt, p.errorf("empty token option")
+	}
+	return opt, err
+}
+
+// value parses the value of an alternative.
+func (p *tagParser) value(opt TokenParseOptions) (TokenParseOptions, error) {
+	switch delim := p.peek(); delim {
+	case '\'', '/':
+		start := p.pos
+		value, ok := p.delimited(delim)
+		ignoreCase := false
+		if ok {
+			ignoreCase, ok = p.flags()
+		}
+		if !ok {
+			// E.g. op,/= or op,'s are not quoted.
+			p.pos = start
+			return p.bareValue(opt)
+		}
+		opt.IgnoreCase = ignoreCase
+		if delim == '/' {
+			opt.ValuePattern = value
+			return opt, opt.compilePattern()
+		}
+		opt.TokenValue = value
+		return opt, nil
+	default:
+		return p.bareValue(opt)
+	}
+}
+
+// bareValue parses the rest of the alternative as the token value.
+func (p *tagParser) bareValue(opt TokenParseOptions) (TokenParseOptions, error) {
+	start := p.pos
+	for !p.done() && p.peek() != '|' {
+		p.pos++
+	}
+	opt.TokenValue = p.src[start:p.pos]
+	return opt, nil
+}
+
+// class parses a reference to a token class and appends its options to opts.
+func (p *tagParser) class(opts []TokenParseOptions) ([]TokenParseOptions, error) {
+	start := p.pos
+	p.pos++ // Skip "#"
+	for !p.done() && !strings.ContainsRune("|*", rune(p.peek())) {
+		p.pos++
+	}
+	name := p.src[start+1 : p.pos]
+	classOpts, ok := lookupTokenClass(name)
+	if !ok {
+		p.pos = start
+		return opts, p.errorf("unknown token class %q", name)
+	}
+	doNotConsume := p.peek() == '*'
+	if doNotConsume {
+		p.pos++
+	}
+	if !p.done() && p.peek() != '|' {
+		return opts, p.errorf("unexpected %q after token class", p.peek())
+	}
+	for _, opt := range classOpts {
+		opt.DoNotConsume = opt.DoNotConsume || doNotConsume
+		opts = append(opts, opt)
+	}
+	return opts, nil
+}
+
+// delimited parses a non-empty value enclosed in delim characters, where \
+// escapes delim and itself.  It returns false if there is no such value.
+func (p *tagParser) delimited(delim byte) (string, bool) {
+	p.pos++
+	var b strings.Builder
+	for !p.done() {
+		c := p.peek()
+		p.pos++
+		switch c {
+		case delim:
+			return b.String(), b.Len() > 0
+		case '\\':
+			if next := p.peek(); next == delim || next == '\\' {
+				c = next
+				p.pos++
+			}
+		}
+		b.WriteByte(c)
+	}
+	return "", false
+}
+
+// flags parses the flags after a quoted value or a regular expression, up to
+// the end of the alternative.  It returns false if they are not valid flags.
+func (p *tagParser) flags() (ignoreCase bool, ok bool) {
+	for !p.done() && p.peek() != '|' {
+		if p.peek() != 'i' {
+			return false, false
+		}
+		ignoreCase = true
+		p.pos++
+	}
+	return ignoreCase, true
+}
+
+// compilePattern compiles the regular expression of the options so that it
+// matches whole values.
+func (o *TokenParseOptions) compilePattern() error {
+	expr := "^(?:" + o.ValuePattern + ")$"
+	if o.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	o.valueRegexp = re
+	return nil
+}
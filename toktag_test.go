@@ -0,0 +1,114 @@
+package grammar
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenOptionsFromTagValue(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want []TokenParseOptions
+	}{
+		{"", nil},
+		{"op", []TokenParseOptions{{TokenType: "op"}}},
+		{"op*", []TokenParseOptions{{TokenType: "op", DoNotConsume: true}}},
+		{"op,,", []TokenParseOptions{{TokenType: "op", TokenValue: ","}}},
+		{"op,'||'", []TokenParseOptions{{TokenType: "op", TokenValue: "||"}}},
+		{"op,'|'|op,','", []TokenParseOptions{{TokenType: "op", TokenValue: "|"}, {TokenType: "op", TokenValue: ","}}},
+		{`op,'\'\\'`, []TokenParseOptions{{TokenType: "op", TokenValue: `'\`}}},
+		{",x", []TokenParseOptions{{TokenValue: "x"}}},
+		{"op,/|op,'", []TokenParseOptions{{TokenType: "op", TokenValue: "/"}, {TokenType: "op", TokenValue: "'"}}},
+		{"op,/=", []TokenParseOptions{{TokenType: "op", TokenValue: "/="}}},
+		{"op,//", []TokenParseOptions{{TokenType: "op", TokenValue: "//"}}},
+		{"op,*/|op,/*", []TokenParseOptions{{TokenType: "op", TokenValue: "*/"}, {TokenType: "op", TokenValue: "/*"}}},
+		{"op,'s|op,'t'", []TokenParseOptions{{TokenType: "op", TokenValue: "'s"}, {TokenType: "op", TokenValue: "t"}}},
+		{"op,'x'z", []TokenParseOptions{{TokenType: "op", TokenValue: "'x'z"}}},
+		{"kw,'select'i|ident", []TokenParseOptions{{TokenType: "kw", TokenValue: "select", IgnoreCase: true}, {TokenType: "ident"}}},
+		{`op,'\'x\''|op,'/x/'`, []TokenParseOptions{{TokenType: "op", TokenValue: "'x'"}, {TokenType: "op", TokenValue: "/x/"}}},
+
+		// Tags which were valid before quoted values and regular expressions
+		// keep their meaning.
+		{"*", []TokenParseOptions{{DoNotConsume: true}}},
+		{"op|", []TokenParseOptions{{TokenType: "op"}}},
+		{"|op||kw*|", []TokenParseOptions{{TokenType: "op"}, {TokenType: "kw", DoNotConsume: true}}},
+		{"op,", []TokenParseOptions{{TokenType: "op"}}},
+		{"a/b|it's*,x", []TokenParseOptions{{TokenType: "a/b"}, {TokenType: "it's", TokenValue: "x", DoNotConsume: true}}},
+		{"op'x'|o*p", []TokenParseOptions{{TokenType: "op'x'"}, {TokenType: "o*p"}}},
+		{"op,don't,x", []TokenParseOptions{{TokenType: "op", TokenValue: "don't,x"}}},
+	}
+	for _, test := range tests {
+		opts, err := tokenOptionsFromTagValue(test.tag)
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.tag, err)
+			continue
+		}
+		if !reflect.DeepEqual(opts.TokenParseOptions, test.want) {
+			t.Errorf("%q: got %+v, want %+v", test.tag, opts.TokenParseOptions, test.want)
+		}
+	}
+}
+
+func TestTokenOptionsFromTagValueErrors(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{",", "at offset 0: empty token option"},
+		{"op|,", "at offset 3: empty token option"},
+		{"op,/(/", "missing closing )"},
+	}
+	for _, test := range tests {
+		_, err := tokenOptionsFromTagValue(test.tag)
+		if err == nil {
+			t.Errorf("%q: expected an error", test.tag)
+		} else if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%q: got error %q, want %q", test.tag, err, test.want)
+		}
+	}
+}
+
+type ttSelect struct {
+	Seq
+	Select Match         `tok:"ident,'select'i"`
+	Items  []SimpleToken `tok:"ident,/[a-z]+/i|number" sep:"op,','"`
+	Or     *Match        `tok:"op,'||'"`
+	Limit  *SimpleToken  `tok:"number,/[1-9][0-9]*/"`
+	End    Match         `tok:"EOF"`
+}
+
+func TestTagValueMatching(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"select a, B, 1", ""},
+		{"SeLeCt a || 10", ""},
+		{"selects a", `token #0 ident with value "selects": expected token with value "select"i`},
+		{"select a || 01", `token #3 number with value "01": expected token with type EOF, or value /[1-9][0-9]*/`},
+		{"select |", `token #1 op with value "|": expected token with type number or EOF, or value /[a-z]+/i or "||" or /[1-9][0-9]*/`},
+	}
+	for _, test := range tests {
+		t.Run(test.src, func(t *testing.T) {
+			var got string
+			if err := parseBothEngines(t, &ttSelect{}, test.src).Err; err != nil {
+				got = err.Error()
+			}
+			if got != test.want {
+				t.Errorf("Got:\n%s\nWant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestInvalidTokTag(t *testing.T) {
+	type emptyType struct {
+		Seq
+		X Match `tok:",|op"`
+	}
+	_, err := calcRuleDef(reflect.TypeOf(emptyType{}))
+	if err == nil || !strings.Contains(err.Error(), "invalid tok tag on field X") {
+		t.Errorf("Got %v, want invalid tok tag error", err)
+	}
+}
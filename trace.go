@@ -0,0 +1,225 @@
+package grammar
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// A Tracer receives events as the parser progresses.  Use WithTracer to
+// install a tracer.
+type Tracer interface {
+	Trace(ev TraceEvent)
+}
+
+// TraceEventKind is the kind of a TraceEvent.
+type TraceEventKind uint8
+
+const (
+	TraceEnterRule  TraceEventKind = iota // Parsing of Dest starts at Pos, with token options Options
+	TraceExitRule                         // Parsing of Dest ends at Pos, Err is nil if it succeeded
+	TraceField                            // Field of Rule is about to be parsed at Pos
+	TraceConsume                          // Token at Pos was matched and consumed
+	TraceSave                             // Position Pos in the token stream was saved
+	TraceRestore                          // The token stream was moved back from From to Pos
+	TraceDrop                             // Token at Pos was dropped
+	TraceMergeError                       // Err was merged into the furthest error so far
+)
+
+var traceEventKindNames = [...]string{
+	TraceEnterRule:  "enter",
+	TraceExitRule:   "exit",
+	TraceField:      "field",
+	TraceConsume:    "consume",
+	TraceSave:       "save",
+	TraceRestore:    "restore",
+	TraceDrop:       "drop",
+	TraceMergeError: "merge error",
+}
+
+func (k TraceEventKind) String() string {
+	if int(k) < len(traceEventKindNames) {
+		return traceEventKindNames[k]
+	}
+	return fmt.Sprintf("TraceEventKind(%d)", k)
+}
+
+// A TraceEvent describes a step taken by the parser.  Which fields are set
+// depends on the kind of the event.
+type TraceEvent struct {
+	Kind    TraceEventKind
+	Depth   int          // Depth of nesting of rules
+	Dest    interface{}  // The value being parsed (enter and exit)
+	Rule    string       // The name of the rule (enter, exit and field)
+	Field   string       // The name of the field (field)
+	Options TokenOptions // The token options (enter)
+	Token   Token        // The token (consume and drop)
+	Pos     int          // The position in the token stream
+	From    int          // The position before restoring (restore)
+	Err     *ParseError  // The error (exit and merge error)
+}
+
+// WithTracer makes the parser send events to t.  It can be combined with
+// other tracing options, in which case all tracers receive all events.
+func WithTracer(t Tracer) ParseOption {
+	return func(s *ParserState) {
+		s.addTracer(t)
+	}
+}
+
+func (s *ParserState) addTracer(t Tracer) {
+	switch prev := s.tracer.(type) {
+	case nil:
+		s.tracer = t
+	case multiTracer:
+		s.tracer = append(prev, t)
+	default:
+		s.tracer = multiTracer{prev, t}
+	}
+}
+
+// trace sends the event to the tracer, which must not be nil.
+func (s *ParserState) trace(ev TraceEvent) {
+	ev.Depth = s.depth
+	s.tracer.Trace(ev)
+}
+
+// traceStream returns the parser state if s is one with a tracer.
+func traceStream(s TokenStream) (*ParserState, bool) {
+	ps, ok := s.(*ParserState)
+	return ps, ok && ps.tracer != nil
+}
+
+type multiTracer []Tracer
+
+func (m multiTracer) Trace(ev TraceEvent) {
+	for _, t := range m {
+		t.Trace(ev)
+	}
+}
+
+// NewLogTracer returns a Tracer which outputs rule and field events to l,
+// indented according to their depth.  It is the tracer used by WithLogger.
+func NewLogTracer(l *log.Logger) Tracer {
+	return logTracer{logger: l}
+}
+
+type logTracer struct {
+	logger *log.Logger
+}
+
+func (t logTracer) Trace(ev TraceEvent) {
+	switch ev.Kind {
+	case TraceEnterRule:
+		t.logf(ev.Depth, "===> %T, %v", ev.Dest, ev.Options)
+	case TraceExitRule:
+		t.logf(ev.Depth, "<=== %s", ev.Err)
+	case TraceField:
+		t.logf(ev.Depth, "  .%s tok #%d", ev.Field, ev.Pos)
+	}
+}
+
+func (t logTracer) logf(depth int, fstr string, args ...interface{}) {
+	t.logger.Printf("% *d"+fstr, append([]interface{}{depth * 2, depth}, args...)...)
+}
+
+// A ChromeTracer writes events in the Trace Event Format, which can be loaded
+// in chrome://tracing or https://ui.perfetto.dev.  Rules are output as
+// duration events and tokens consumed or dropped, backtracking and errors as
+// instant events.  Save events are not output.
+//
+// Close must be called when parsing is finished to complete the output.
+type ChromeTracer struct {
+	out   io.Writer
+	start time.Time
+	count int
+	err   error
+}
+
+var _ Tracer = (*ChromeTracer)(nil)
+
+// NewChromeTracer returns a ChromeTracer writing to out.
+func NewChromeTracer(out io.Writer) *ChromeTracer {
+	return &ChromeTracer{out: out, start: time.Now()}
+}
+
+type chromeEvent struct {
+	Name  string            `json:"name"`
+	Cat   string            `json:"cat"`
+	Phase string            `json:"ph"`
+	Scope string            `json:"s,omitempty"`
+	TS    float64           `json:"ts"`
+	PID   int               `json:"pid"`
+	TID   int               `json:"tid"`
+	Args  map[string]string `json:"args,omitempty"`
+}
+
+func (t *ChromeTracer) Trace(ev TraceEvent) {
+	ce := chromeEvent{
+		Cat:   "grammar",
+		Phase: "i",
+		Scope: "t",
+		TS:    float64(time.Since(t.start).Nanoseconds()) / 1000,
+		PID:   1,
+		TID:   1,
+		Args:  map[string]string{"pos": fmt.Sprint(ev.Pos)},
+	}
+	switch ev.Kind {
+	case TraceEnterRule:
+		ce.Name, ce.Phase, ce.Scope = ev.Rule, "B", ""
+		if len(ev.Options.TokenParseOptions) > 0 {
+			ce.Args["tok"] = tokenOptionsLabel(ev.Options)
+		}
+	case TraceExitRule:
+		ce.Name, ce.Phase, ce.Scope = ev.Rule, "E", ""
+		if ev.Err != nil {
+			ce.Args["error"] = ev.Err.Error()
+		}
+	case TraceConsume, TraceDrop:
+		ce.Name = ev.Kind.String()
+		ce.Args["type"] = ev.Token.Type()
+		ce.Args["value"] = ev.Token.Value()
+	case TraceRestore:
+		ce.Name = "restore"
+		ce.Args["from"] = fmt.Sprint(ev.From)
+	case TraceMergeError:
+		ce.Name = "merge error"
+		ce.Args["error"] = ev.Err.Error()
+	default:
+		return
+	}
+	t.write(ce)
+}
+
+func (t *ChromeTracer) write(ce chromeEvent) {
+	if t.err != nil {
+		return
+	}
+	data, err := json.Marshal(ce)
+	if err != nil {
+		t.err = err
+		return
+	}
+	sep := ",\n"
+	if t.count == 0 {
+		sep = "[\n"
+	}
+	t.count++
+	_, t.err = io.WriteString(t.out, sep+string(data))
+}
+
+// Close completes the output and returns the first error that occurred while
+// writing it, if any.
+func (t *ChromeTracer) Close() error {
+	if t.err != nil {
+		return t.err
+	}
+	end := "\n]\n"
+	if t.count == 0 {
+		end = "[]\n"
+	}
+	_, t.err = io.WriteString(t.out, end)
+	return t.err
+}
@@ -0,0 +1,129 @@
+package grammar
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+)
+
+type recordingTracer []TraceEvent
+
+func (r *recordingTracer) Trace(ev TraceEvent) {
+	*r = append(*r, ev)
+}
+
+func parseWithOptions(t *testing.T, src string, opts ...ParseOption) {
+	t.Helper()
+	stream, err := testTokenise(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expr testExpr
+	if err := Parse(&expr, stream, opts...); err != nil {
+		t.Fatalf("Error parsing %q: %s", src, err)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	var events recordingTracer
+	parseWithOptions(t, "a", WithTracer(&events))
+	var got []string
+	for _, ev := range events {
+		var desc string
+		switch ev.Kind {
+		case TraceEnterRule, TraceExitRule:
+			desc = ev.Rule
+		case TraceField:
+			desc = ev.Rule + "." + ev.Field
+		case TraceConsume, TraceDrop:
+			desc = ev.Token.Value()
+		case TraceRestore:
+			desc = fmt.Sprintf("%d<-%d", ev.Pos, ev.From)
+		case TraceMergeError:
+			desc = ev.Err.Token.Value()
+		case TraceSave:
+			desc = fmt.Sprint(ev.Pos)
+		}
+		got = append(got, fmt.Sprintf("%d %s %s", ev.Depth, ev.Kind, desc))
+	}
+	want := []string{
+		"0 save 0",
+		"0 enter testExpr",
+		"1 field testExpr.Number",
+		"1 save 0",
+		"1 enter SimpleToken",
+		"2 save 0",
+		"1 merge error a",
+		"1 exit SimpleToken",
+		"1 restore 0<-1",
+		"1 field testExpr.Atom",
+		"1 save 0",
+		"1 enter SimpleToken",
+		"2 save 0",
+		"2 consume a",
+		"1 exit SimpleToken",
+		"0 exit testExpr",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Got:\n%s\nWant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLogTracer(t *testing.T) {
+	var b strings.Builder
+	parseWithOptions(t, "a", WithLogger(log.New(&b, "", 0)))
+	want := ` 0===> *grammar.testExpr, {[]}
+ 1  .Number tok #0
+ 1===> *grammar.SimpleToken, {[type number]}
+ 1<=== token #0 atom with value "a": expected token with type number
+ 1  .Atom tok #0
+ 1===> *grammar.SimpleToken, {[type atom]}
+ 1<=== <nil>
+ 0<=== <nil>
+`
+	if b.String() != want {
+		t.Errorf("Got:\n%s\nWant:\n%s", b.String(), want)
+	}
+}
+
+func TestChromeTracer(t *testing.T) {
+	var b strings.Builder
+	tracer := NewChromeTracer(&b)
+	parseWithOptions(t, "(a 1)", WithTracer(tracer))
+	if err := tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var events []struct {
+		Name  string
+		Phase string `json:"ph"`
+		Args  map[string]string
+	}
+	if err := json.Unmarshal([]byte(b.String()), &events); err != nil {
+		t.Fatalf("Invalid JSON: %s\n%s", err, b.String())
+	}
+	var stack []string
+	var consumed []string
+	for _, ev := range events {
+		switch ev.Phase {
+		case "B":
+			stack = append(stack, ev.Name)
+		case "E":
+			if len(stack) == 0 || stack[len(stack)-1] != ev.Name {
+				t.Fatalf("Unbalanced end event for %s", ev.Name)
+			}
+			stack = stack[:len(stack)-1]
+		case "i":
+			if ev.Name == "consume" {
+				consumed = append(consumed, ev.Args["value"])
+			}
+		}
+	}
+	if len(stack) != 0 {
+		t.Errorf("Unfinished rules: %v", stack)
+	}
+	if got := strings.Join(consumed, " "); got != "( a 1 )" {
+		t.Errorf("Consumed %q", got)
+	}
+}
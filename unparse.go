@@ -0,0 +1,135 @@
+package grammar
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Unparse returns a sequence of tokens which parses to the rule r.  Tokens
+// matched by Match fields and separators are recreated from the tok and sep
+// tags, so Unparse fails if they do not specify a value.  Separators which are
+// rules are recreated from the zero value of the rule.  Dropped tokens and
+// tokens which were not consumed (with the "*" tag suffix) are not output.
+func Unparse(r interface{}) ([]Token, error) {
+	v := reflect.ValueOf(r)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	var toks []Token
+	err := unparse(v, TokenOptions{}, &toks)
+	return toks, err
+}
+
+func unparse(v reflect.Value, opts TokenOptions, toks *[]Token) error {
+	ruleDef, err := getRuleDef(v.Type())
+	if err != nil {
+		return unparseLeaf(v, opts, toks)
+	}
+	for _, ruleField := range ruleDef.Fields {
+		fieldV := v.Field(ruleField.Index)
+		switch {
+		case ruleField.Lookahead != NoLookahead:
+			continue
+		case ruleField.Pointer:
+			if fieldV.IsNil() {
+				continue
+			}
+			err = unparse(fieldV.Elem(), ruleField.TokenOptions, toks)
+		case ruleField.Array:
+			err = unparseItems(fieldV, ruleField, toks)
+			if ruleDef.OneOf && fieldV.Len() == 0 {
+				continue
+			}
+		default:
+			err = unparse(fieldV, ruleField.TokenOptions, toks)
+		}
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", ruleDef.Name, ruleField.Name, err)
+		}
+		if ruleDef.OneOf {
+			break
+		}
+	}
+	return nil
+}
+
+// unparseItems appends the tokens for the items of a repeated field, with
+// separators between them.  Leading and trailing separators are only output
+// if they are required.
+func unparseItems(v reflect.Value, ruleField RuleField, toks *[]Token) error {
+	n := v.Len()
+	if n > 0 && ruleField.Leading == SepRequire {
+		if err := unparseSep(ruleField, toks); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			if err := unparseSep(ruleField, toks); err != nil {
+				return err
+			}
+		}
+		if err := unparse(v.Index(i), ruleField.TokenOptions, toks); err != nil {
+			return err
+		}
+	}
+	if n > 0 && ruleField.Trailing == SepRequire {
+		return unparseSep(ruleField, toks)
+	}
+	return nil
+}
+
+// unparseSep appends the tokens for a separator of a repeated field.  Rule
+// separators are not kept in the tree, so they are recreated from the zero
+// value of the rule, which must give some tokens.
+func unparseSep(ruleField RuleField, toks *[]Token) error {
+	if ruleField.SepRule == nil {
+		return unparseToken(ruleField.SepOptions, toks)
+	}
+	n := len(*toks)
+	err := unparse(reflect.Zero(ruleField.SepRule), TokenOptions{}, toks)
+	if err == nil && len(*toks) == n {
+		err = errors.New("no tokens")
+	}
+	if err != nil {
+		return fmt.Errorf("cannot recreate separator %s: %w", ruleTypeName(ruleField.SepRule), err)
+	}
+	return nil
+}
+
+func unparseLeaf(v reflect.Value, opts TokenOptions, toks *[]Token) error {
+	tok, ok := v.Interface().(Token)
+	if !ok {
+		return unparseToken(opts, toks)
+	}
+	for _, opt := range opts.TokenParseOptions {
+		if opt.matches(tok) {
+			if !opt.DoNotConsume {
+				*toks = append(*toks, tok)
+			}
+			return nil
+		}
+	}
+	if len(opts.TokenParseOptions) > 0 {
+		*toks = append(*toks, tok)
+	}
+	return nil
+}
+
+// unparseToken appends a token built from the first of the token options, if
+// there is one.
+func unparseToken(opts TokenOptions, toks *[]Token) error {
+	if len(opts.TokenParseOptions) == 0 {
+		return nil
+	}
+	opt := opts.TokenParseOptions[0]
+	if opt.DoNotConsume {
+		return nil
+	}
+	if opt.TokenValue == "" {
+		return fmt.Errorf("cannot recreate token with %s", opt)
+	}
+	*toks = append(*toks, SimpleToken{TokType: opt.TokenType, TokValue: opt.TokenValue})
+	return nil
+}
@@ -0,0 +1,44 @@
+package grammar
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnparse(t *testing.T) {
+	type Items struct {
+		Seq
+		Items []testExpr `sep:"op,,"`
+		End   Match      `tok:"op,;"`
+	}
+	toks := []Token{
+		SimpleToken{TokType: "atom", TokValue: "a"},
+		SimpleToken{TokType: "op", TokValue: ","},
+		SimpleToken{TokType: "bkt", TokValue: "("},
+		SimpleToken{TokType: "number", TokValue: "1"},
+		SimpleToken{TokType: "bkt", TokValue: ")"},
+		SimpleToken{TokType: "op", TokValue: ";"},
+	}
+	var items Items
+	if err := Parse(&items, NewSimpleTokenStream(toks)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Unparse(&items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := toks
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+}
+
+func TestUnparseError(t *testing.T) {
+	type Rule struct {
+		Seq
+		Open Match `tok:"op"`
+	}
+	if _, err := Unparse(Rule{}); err == nil {
+		t.Error("Expected an error for a Match with no value")
+	}
+}
@@ -0,0 +1,76 @@
+package grammar
+
+// WithUserState sets the initial user state of the parser (see
+// ParserState.UserState).
+func WithUserState(v interface{}) ParseOption {
+	return func(s *ParserState) {
+		s.userState = v
+	}
+}
+
+// UserState returns the user state of the parser.  It can be used by rules
+// which depend on context, e.g. a Validate method which checks that an
+// identifier was declared or a rule which only matches type names.
+func (s *ParserState) UserState() interface{} {
+	return s.userState
+}
+
+// SetUserState changes the user state of the parser.  When the parser
+// backtracks, e.g. because a rule or an alternative fails, the changes made
+// since the rule or alternative was started are rolled back, even if no token
+// was consumed.  For this to work the state should be treated as immutable, so
+// e.g. a symbol table should be copied rather than modified in place.
+func (s *ParserState) SetUserState(v interface{}) {
+	s.stateJournal = append(s.stateJournal, stateChange{
+		pos:  s.TokenStream.Save(),
+		prev: s.userState,
+	})
+	s.userState = v
+}
+
+// A stateChange records the user state before it was changed at position pos.
+type stateChange struct {
+	pos  int
+	prev interface{}
+}
+
+// A savePoint is a position in the token stream together with the length of
+// the user state journal when it was saved.  The parser restores save points
+// rather than positions, so that changes made at the saved position are also
+// rolled back.
+type savePoint struct {
+	pos     int
+	journal int
+}
+
+// savePointAt returns a save point for the current position pos.
+func (s *ParserState) savePointAt(pos int) savePoint {
+	return savePoint{pos: pos, journal: len(s.stateJournal)}
+}
+
+// restoreSavePoint returns the token stream to the position of p and rolls
+// back the changes made to the user state since p was saved.
+func (s *ParserState) restoreSavePoint(p savePoint) {
+	s.Restore(p.pos)
+	s.rollbackUserState(p.journal)
+}
+
+// rollbackUserState undoes the changes made to the user state after the first
+// n changes in the journal.
+func (s *ParserState) rollbackUserState(n int) {
+	for i := len(s.stateJournal) - 1; i >= n; i-- {
+		s.userState = s.stateJournal[i].prev
+	}
+	s.stateJournal = s.stateJournal[:n]
+}
+
+// rollbackUserStateAfter undoes the changes made to the user state after
+// position pos.  As the journal is rolled back on every restore, the positions
+// in it are in increasing order.
+func (s *ParserState) rollbackUserStateAfter(pos int) {
+	n := len(s.stateJournal)
+	for n > 0 && s.stateJournal[n-1].pos > pos {
+		n--
+	}
+	s.rollbackUserState(n)
+}
@@ -0,0 +1,185 @@
+package grammar
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// The user state is the list of type names declared so far.
+func usTypeNames(s *ParserState) []string {
+	names, _ := s.UserState().([]string)
+	return names
+}
+
+type usProgram struct {
+	Seq
+	Stmts []usStmt
+	End   Match `tok:"EOF"`
+}
+
+type usStmt struct {
+	OneOf
+	Typedef *usTypedef
+	Decl    *usDecl
+	Expr    *usExpr
+}
+
+// E.g. "a is type;" declares the type a.
+type usTypedef struct {
+	Seq
+	Name usNewType
+	Is   Match `tok:"ident,is"`
+	Type Match `tok:"ident,type"`
+	Semi Match `tok:"op,;"`
+}
+
+type usNewType struct {
+	Seq
+	Name SimpleToken `tok:"ident"`
+}
+
+func (t *usNewType) Validate(s *ParserState) error {
+	names := usTypeNames(s)
+	s.SetUserState(append(names[:len(names):len(names)], t.Name.Value()))
+	return nil
+}
+
+type usDecl struct {
+	Seq
+	Type usTypeName
+	Star Match       `tok:"op,*"`
+	Name SimpleToken `tok:"ident"`
+	Semi Match       `tok:"op,;"`
+}
+
+type usTypeName struct {
+	Seq
+	Name SimpleToken `tok:"ident"`
+}
+
+func (t *usTypeName) Validate(s *ParserState) error {
+	for _, name := range usTypeNames(s) {
+		if name == t.Name.Value() {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not a type", t.Name.Value())
+}
+
+type usExpr struct {
+	Seq
+	Left  SimpleToken `tok:"ident"`
+	Op    SimpleToken `tok:"op,*|ident,is"`
+	Right SimpleToken `tok:"ident"`
+	Semi  Match       `tok:"op,;"`
+}
+
+func TestUserState(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"a * b;", "expr"},
+		{"a is type; a * b;", "typedef a, decl a"},
+		{"a is good; a * b;", "expr, expr"},
+		{"a is type; b is type; b * c;", "typedef a, typedef b, decl b"},
+	}
+	for _, test := range tests {
+		t.Run(test.src, func(t *testing.T) {
+			res := parseBothEngines(t, &usProgram{}, test.src)
+			if res.Err != nil {
+				t.Fatal(res.Err)
+			}
+			var kinds []string
+			for _, stmt := range res.Tree.(*usProgram).Stmts {
+				switch {
+				case stmt.Typedef != nil:
+					kinds = append(kinds, "typedef "+stmt.Typedef.Name.Name.Value())
+				case stmt.Decl != nil:
+					kinds = append(kinds, "decl "+stmt.Decl.Type.Name.Value())
+				default:
+					kinds = append(kinds, "expr")
+				}
+			}
+			if got := strings.Join(kinds, ", "); got != test.want {
+				t.Errorf("Got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUserStateRollback(t *testing.T) {
+	stream, err := testLangTokenise("a b c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ParserState{TokenStream: stream}
+	WithUserState("init")(s)
+	s.Next()
+	s.SetUserState("a")
+	s.Next()
+	s.SetUserState("b")
+	s.SetUserState("bb")
+	s.Restore(2)
+	if got := s.UserState(); got != "bb" {
+		t.Errorf("Got %v at 2, want bb", got)
+	}
+	s.Restore(1)
+	if got := s.UserState(); got != "a" {
+		t.Errorf("Got %v at 1, want a", got)
+	}
+	s.Next()
+	s.SetUserState("c")
+	s.Restore(0)
+	if got := s.UserState(); got != "init" {
+		t.Errorf("Got %v at 0, want init", got)
+	}
+}
+
+// usEnterScope sets the user state without consuming any token.
+type usEnterScope struct{}
+
+func (e *usEnterScope) Parse(r interface{}, s *ParserState, opts TokenOptions) *ParseError {
+	s.SetUserState("scope")
+	return nil
+}
+
+type usAlt struct {
+	OneOf
+	Scoped *usScoped
+	Plain  *usPlain
+}
+
+type usScoped struct {
+	Seq
+	Enter usEnterScope
+	Name  SimpleToken `tok:"ident"`
+	Semi  Match       `tok:"op,;"`
+}
+
+type usPlain struct {
+	Seq
+	Name SimpleToken `tok:"ident"`
+}
+
+func (p *usPlain) Validate(s *ParserState) error {
+	if st := s.UserState(); st != nil {
+		return fmt.Errorf("got user state %v", st)
+	}
+	return nil
+}
+
+// The state set by Scoped at the start of the input must be rolled back when
+// Scoped fails, even though it was set before consuming any token.
+func TestUserStateRollbackAtStart(t *testing.T) {
+	for _, opts := range [][]ParseOption{nil, {WithAmbiguityAudit(&AmbiguityAudit{})}} {
+		res := parseBothEngines(t, &usAlt{}, "a", opts...)
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		if res.Tree.(*usAlt).Plain == nil {
+			t.Errorf("Got %s, want Plain", res)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package grammar
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+var errMismatchedTag = errors.New("mismatched tag")
+
+// A number which fits in a byte.
+type vdByte struct {
+	Seq
+	Num SimpleToken `tok:"number"`
+}
+
+func (b *vdByte) Validate(s *ParserState) error {
+	_, err := strconv.ParseUint(b.Num.Value(), 10, 8)
+	return err
+}
+
+type vdNumber struct {
+	OneOf
+	Byte  *vdByte
+	Large *SimpleToken `tok:"number"`
+}
+
+type vdElement struct {
+	Seq
+	OpenL   Match       `tok:"op,<"`
+	Open    SimpleToken `tok:"ident"`
+	OpenR   Match       `tok:"op,>"`
+	Numbers []vdNumber
+	CloseL  Match       `tok:"op,<"`
+	Slash   Match       `tok:"op,/"`
+	Close   SimpleToken `tok:"ident"`
+	CloseR  Match       `tok:"op,>"`
+}
+
+func (e vdElement) Validate(s *ParserState) error {
+	if e.Open.Value() != e.Close.Value() {
+		return fmt.Errorf("%w: %s closed by %s", errMismatchedTag, e.Open.Value(), e.Close.Value())
+	}
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"<a> 1 255 256 </a>", "byte 1, byte 255, large 256"},
+		{"<a> 1 </b>", `token #0 op with value "<": mismatched tag: a closed by b`},
+	}
+	for _, test := range tests {
+		t.Run(test.src, func(t *testing.T) {
+			res := parseBothEngines(t, &vdElement{}, test.src)
+			var got string
+			if res.Err != nil {
+				got = res.Err.Error()
+				if !errors.Is(res.Err, errMismatchedTag) {
+					t.Errorf("Expected errMismatchedTag, got %s", res.Err)
+				}
+			} else {
+				for i, n := range res.Tree.(*vdElement).Numbers {
+					if i > 0 {
+						got += ", "
+					}
+					if n.Byte != nil {
+						got += "byte " + n.Byte.Num.Value()
+					} else {
+						got += "large " + n.Large.Value()
+					}
+				}
+			}
+			if got != test.want {
+				t.Errorf("Got:\n%s\nWant:\n%s", got, test.want)
+			}
+		})
+	}
+}
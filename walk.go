@@ -0,0 +1,135 @@
+package grammar
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// A PathStep locates a node in its parent rule: the rule the field belongs to,
+// the name of the field and, for repeated fields, the index of the item.
+type PathStep struct {
+	Rule  string // Name of the parent rule
+	Field string // Name of the field in the parent rule
+	Index int    // Index of the item in a repeated field, -1 otherwise
+}
+
+func (p PathStep) String() string {
+	if p.Index >= 0 {
+		return fmt.Sprintf("%s[%d]", p.Field, p.Index)
+	}
+	return p.Field
+}
+
+// A Path locates a node in a parse tree from the root.  The root itself has an
+// empty path.
+type Path []PathStep
+
+// String returns a representation of the path such as "Dict.Items[1].Key".
+func (p Path) String() string {
+	parts := make([]string, len(p))
+	for i, step := range p {
+		parts[i] = step.String()
+	}
+	return strings.Join(parts, ".")
+}
+
+// A WalkAction tells Walk how to proceed after visiting a node.
+type WalkAction int
+
+const (
+	WalkContinue     WalkAction = iota // Carry on walking the tree
+	WalkSkipChildren                   // Do not visit the children of this node
+	WalkStop                           // Stop walking the tree altogether
+)
+
+// A WalkFunc is called by Walk for each node in the tree.  The path is only
+// valid for the duration of the call and must be copied if it is retained.
+type WalkFunc func(node interface{}, path Path) WalkAction
+
+// Walk traverses the parse tree rooted at node in depth-first order, calling
+// fn for each node before its children.  Children of a rule are its populated
+// fields, visited in declaration order (each item of a repeated field is a
+// separate child).  Values which are not rules (e.g. tokens) are leaves.
+func Walk(node interface{}, fn WalkFunc) {
+	WalkPrePost(node, fn, nil)
+}
+
+// WalkPrePost is like Walk but also calls post for each node after its
+// children have been visited.  Either of pre and post may be nil.  Returning
+// WalkSkipChildren from post has the same effect as WalkContinue.
+func WalkPrePost(node interface{}, pre, post WalkFunc) {
+	w := walker{pre: pre, post: post}
+	w.walk(reflect.ValueOf(node))
+}
+
+type walker struct {
+	pre, post WalkFunc
+	path      Path
+}
+
+func (w *walker) walk(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return true
+		}
+		v = v.Elem()
+	}
+	node := v.Interface()
+	if w.pre != nil {
+		switch w.pre(node, w.path) {
+		case WalkStop:
+			return false
+		case WalkSkipChildren:
+			return w.visitPost(node)
+		}
+	}
+	ok := forEachChild(v, func(child reflect.Value, step PathStep) bool {
+		w.path = append(w.path, step)
+		ok := w.walk(child)
+		w.path = w.path[:len(w.path)-1]
+		return ok
+	})
+	return ok && w.visitPost(node)
+}
+
+func (w *walker) visitPost(node interface{}) bool {
+	return w.post == nil || w.post(node, w.path) != WalkStop
+}
+
+// forEachChild calls fn for each populated child of the rule value v, in
+// declaration order, stopping as soon as fn returns false.  It returns false
+// if it was stopped.  If v is not a rule, it has no children.
+func forEachChild(v reflect.Value, fn func(child reflect.Value, step PathStep) bool) bool {
+	ruleDef, err := getRuleDef(v.Type())
+	if err != nil {
+		return true
+	}
+	for _, ruleField := range ruleDef.Fields {
+		fieldV := v.Field(ruleField.Index)
+		step := PathStep{Rule: ruleDef.Name, Field: ruleField.Name, Index: -1}
+		switch {
+		case ruleField.Lookahead != NoLookahead:
+			continue
+		case ruleField.Pointer:
+			if !fieldV.IsNil() && !fn(fieldV.Elem(), step) {
+				return false
+			}
+		case ruleField.Array:
+			for i := 0; i < fieldV.Len(); i++ {
+				step.Index = i
+				if !fn(fieldV.Index(i), step) {
+					return false
+				}
+			}
+		default:
+			if !fn(fieldV, step) {
+				return false
+			}
+		}
+	}
+	return true
+}
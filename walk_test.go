@@ -0,0 +1,59 @@
+package grammar
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	expr := mustParseTestExpr(t, "(a (b 1) c)")
+	var got []string
+	Walk(expr, func(node interface{}, path Path) WalkAction {
+		if tok, ok := node.(SimpleToken); ok {
+			got = append(got, path.String()+"="+tok.TokValue)
+		}
+		return WalkContinue
+	})
+	want := []string{
+		"List.Items[0].Atom=a",
+		"List.Items[1].List.Items[0].Atom=b",
+		"List.Items[1].List.Items[1].Number=1",
+		"List.Items[2].Atom=c",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWalkPrePostActions(t *testing.T) {
+	expr := mustParseTestExpr(t, "(a (b 1) c)")
+	var got []string
+	WalkPrePost(expr,
+		func(node interface{}, path Path) WalkAction {
+			if _, ok := node.(testList); ok && len(path) > 1 {
+				got = append(got, "skip "+path.String())
+				return WalkSkipChildren
+			}
+			return WalkContinue
+		},
+		func(node interface{}, path Path) WalkAction {
+			if tok, ok := node.(SimpleToken); ok && tok.TokValue == "c" {
+				got = append(got, "stop "+path.String())
+				return WalkStop
+			}
+			if _, ok := node.(testExpr); ok {
+				got = append(got, "post "+path.String())
+			}
+			return WalkContinue
+		},
+	)
+	want := []string{
+		"post List.Items[0]",
+		"skip List.Items[1].List",
+		"post List.Items[1]",
+		"stop List.Items[2].Atom",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}